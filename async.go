@@ -0,0 +1,159 @@
+package resonatefuse
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// OverflowPolicy decides what an asynchronous hook does when its queue is full
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock waits for room in the queue
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued request
+	OverflowDropOldest
+	// OverflowFail fails the operation with EAGAIN (post hooks only log)
+	OverflowFail
+)
+
+// asyncQueue is a bounded queue of hook calls served by a pool of workers,
+// the workers run from start until close
+type asyncQueue struct {
+	jobs    chan func()
+	policy  OverflowPolicy
+	workers int
+
+	mu      sync.Mutex
+	idle    *sync.Cond
+	pending int
+
+	// closeMu keeps the queue from being closed while a job is being pushed
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+func newAsyncQueue(workers, size int, policy OverflowPolicy) *asyncQueue {
+	q := &asyncQueue{
+		jobs:    make(chan func(), size),
+		policy:  policy,
+		workers: workers,
+	}
+	q.idle = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// start launches the workers of the queue
+func (q *asyncQueue) start() {
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+}
+
+// close stops the workers once they handled the queued jobs, jobs pushed
+// afterwards fail
+func (q *asyncQueue) close() {
+	q.closeMu.Lock()
+	defer q.closeMu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+}
+
+func (q *asyncQueue) work() {
+	for job := range q.jobs {
+		job()
+		q.done()
+	}
+}
+
+func (q *asyncQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending--
+	if q.pending == 0 {
+		q.idle.Broadcast()
+	}
+}
+
+// push queues job according to the overflow policy of the queue
+func (q *asyncQueue) push(job func()) error {
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+
+	if q.closed {
+		return NewHookError(syscall.EIO, "asynchronous hook queue is closed")
+	}
+
+	q.mu.Lock()
+	q.pending++
+	q.mu.Unlock()
+
+	switch q.policy {
+	case OverflowBlock:
+		q.jobs <- job
+		return nil
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.jobs <- job:
+				return nil
+			default:
+			}
+
+			select {
+			case <-q.jobs:
+				q.done()
+			default:
+			}
+		}
+
+	default:
+		select {
+		case q.jobs <- job:
+			return nil
+		default:
+			q.done()
+			return NewHookError(syscall.EAGAIN, "asynchronous hook queue is full")
+		}
+	}
+}
+
+// drain waits until every queued job has been handled
+func (q *asyncQueue) drain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.pending > 0 {
+		q.idle.Wait()
+	}
+}
+
+// Async makes a hook run in the background on a copy of the request, served
+// by workers from a queue holding up to size requests. Asynchronous hooks
+// cannot fail or change the operation, their errors are only logged.
+func Async(workers, size int, policy OverflowPolicy) HookSetting {
+	return func(hs *hookSettings) error {
+		if workers < 1 {
+			return errors.Errorf("asynchronous hook needs at least one worker (got %v)", workers)
+		}
+
+		if size < 1 {
+			return errors.Errorf("asynchronous hook needs a queue of at least one request (got %v)", size)
+		}
+
+		if policy > OverflowFail {
+			return errors.Errorf("unknown overflow policy (%v)", policy)
+		}
+
+		hs.async = newAsyncQueue(workers, size, policy)
+		return nil
+	}
+}
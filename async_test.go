@@ -0,0 +1,63 @@
+package resonatefuse
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsyncQueue(t *testing.T) {
+	var mu sync.Mutex
+	var handled []int
+
+	q := newAsyncQueue(2, 4, OverflowBlock)
+	q.start()
+	for i := 0; i < 16; i++ {
+		i := i
+		assert.Nil(t, q.push(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, i)
+		}))
+	}
+
+	q.drain()
+	assert.Len(t, handled, 16)
+
+	q.close()
+	assert.Equal(t, fuse.Errno(syscall.EIO), fuse.ToErrno(q.push(func() {})))
+}
+
+func TestAsyncQueueOverflow(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	blocker := func() {
+		started <- struct{}{}
+		<-release
+	}
+
+	// The worker is stuck on the first job and the queue holds one more
+	q := newAsyncQueue(1, 1, OverflowFail)
+	q.start()
+	assert.Nil(t, q.push(blocker))
+	<-started
+	assert.Nil(t, q.push(func() {}))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), fuse.ToErrno(q.push(func() {})))
+	release <- struct{}{}
+	q.drain()
+
+	var dropped, kept bool
+	q = newAsyncQueue(1, 1, OverflowDropOldest)
+	q.start()
+	assert.Nil(t, q.push(blocker))
+	<-started
+	assert.Nil(t, q.push(func() { dropped = true }))
+	assert.Nil(t, q.push(func() { kept = true }))
+	release <- struct{}{}
+	q.drain()
+
+	assert.False(t, dropped)
+	assert.True(t, kept)
+}
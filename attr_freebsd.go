@@ -3,8 +3,6 @@
 package resonatefuse
 
 import (
-	"context"
-	"log"
 	"os"
 	"syscall"
 	"time"
@@ -13,39 +11,25 @@ import (
 	"github.com/pkg/errors"
 )
 
-// Attr returns some attributes about the file
-func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
-	log.Println("Attring", f.FFNode.Name())
-
-	info, err := os.Lstat(f.FFNode.fs.realify(f.FFNode.Path()))
-	if err != nil {
-		err = errors.Wrapf(err, "could not retrieve file (%v) info", f.FFNode.fs.realify(f.FFNode.Path()))
-		log.Println(err)
-		return fuse.ENOENT
-	}
-
+// fileAttr converts the information about a file in the origin to its attributes
+func fileAttr(info os.FileInfo) (fuse.Attr, error) {
 	stat, ok := info.Sys().(*syscall.Stat_t)
 	if !ok {
-		err = errors.New("file system not supported")
-		log.Println(err)
-		return fuse.ENOENT
+		return fuse.Attr{}, errors.New("file system not supported")
 	}
 
-	a.Inode = stat.Ino
-	a.Nlink = uint32(stat.Nlink)
-	a.Uid = stat.Uid
-	a.Gid = stat.Gid
-	a.Rdev = uint32(stat.Rdev)
-	a.Mode = info.Mode()
-	a.Size = uint64(info.Size())
-	a.Atime = time.Unix(stat.Atimespec.Unix())
-	a.Mtime = time.Unix(stat.Mtimespec.Unix())
-	a.Ctime = time.Unix(stat.Ctimespec.Unix())
-	a.Blocks = uint64(stat.Blocks)
-	a.BlockSize = uint32(stat.Blksize)
-
-	return nil
+	return fuse.Attr{
+		Inode:     stat.Ino,
+		Nlink:     uint32(stat.Nlink),
+		Uid:       stat.Uid,
+		Gid:       stat.Gid,
+		Rdev:      uint32(stat.Rdev),
+		Mode:      info.Mode(),
+		Size:      uint64(info.Size()),
+		Atime:     time.Unix(stat.Atimespec.Unix()),
+		Mtime:     time.Unix(stat.Mtimespec.Unix()),
+		Ctime:     time.Unix(stat.Ctimespec.Unix()),
+		Blocks:    uint64(stat.Blocks),
+		BlockSize: uint32(stat.Blksize),
+	}, nil
 }
@@ -0,0 +1,107 @@
+package resonatefuse
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/pkg/errors"
+)
+
+// Backend stores the files of a volume, paths are slash separated and
+// relative to the root of the volume ("." being the root itself)
+//
+// Errors that wrap a syscall.Errno are handed to the kernel with that errno
+type Backend interface {
+	// Stat returns the attributes of the file at path without following symlinks
+	Stat(path string) (fuse.Attr, error)
+	// ReadDir returns the files in the directory at path
+	ReadDir(path string) ([]os.FileInfo, error)
+	// Readlink returns the target of the symlink at path
+	Readlink(path string) (string, error)
+
+	// Open opens the regular file at path with the access mode, append,
+	// truncate and sync flags of os.OpenFile
+	Open(path string, flags int) (BackendFile, error)
+	// Create creates an empty regular file at path (it is fine if it exists)
+	// and opens it with flags like Open, a new file is opened even when mode
+	// does not allow the access asked for (like open(2) with O_CREAT)
+	Create(path string, mode os.FileMode, flags int) (BackendFile, error)
+	// Mkdir creates a directory at path
+	Mkdir(path string, mode os.FileMode) error
+	// Remove removes the file or empty directory at path
+	Remove(path string) error
+	// Rename moves the file at oldPath to newPath, replacing what is there
+	Rename(oldPath, newPath string) error
+	// Link makes newPath a hardlink to the regular file at oldPath
+	Link(oldPath, newPath string) error
+	// Symlink creates a symlink at path pointing to target
+	Symlink(target, path string) error
+
+	// Chmod changes the permissions of the file at path
+	Chmod(path string, mode os.FileMode) error
+	// Truncate changes the size of the regular file at path
+	Truncate(path string, size int64) error
+	// Chtimes changes the access and modification times of the file at path
+	// (symlinks themselves, not the files they point to)
+	Chtimes(path string, atime, mtime time.Time) error
+}
+
+// BackendFile is a regular file opened in a backend, Write appends when the
+// file was opened for appending
+type BackendFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Writer
+	io.Closer
+
+	// Sync commits the contents of the file to stable storage
+	Sync() error
+}
+
+// BackendSyncer is implemented by backends that can commit a file or
+// directory to stable storage without it being open
+type BackendSyncer interface {
+	Sync(path string) error
+}
+
+// BackendChowner is implemented by backends that keep file ownership,
+// ownership changes fail with EPERM on other backends
+type BackendChowner interface {
+	// Lchown changes the owner of the file at path, -1 leaves an id unchanged
+	Lchown(path string, uid, gid int) error
+}
+
+// BackendMknoder is implemented by backends that can hold fifos, sockets and
+// devices, making them fails with EPERM on other backends
+type BackendMknoder interface {
+	Mknod(path string, mode os.FileMode, rdev uint32) error
+}
+
+// BackendXattrer is implemented by backends that keep extended attributes,
+// they are not supported (ENOTSUP) on other backends
+type BackendXattrer interface {
+	Getxattr(path, name string) ([]byte, error)
+	Listxattr(path string) ([]string, error)
+	Setxattr(path, name string, value []byte, flags uint32) error
+	Removexattr(path, name string) error
+}
+
+// BackendStatfser is implemented by backends that know their capacity, the
+// capacity of other backends is reported as zero
+type BackendStatfser interface {
+	Statfs() (*StatfsRequest, error)
+}
+
+// BackendOption stores the files of the volume in b instead of the origin directory
+func BackendOption(b Backend) Option {
+	return func(rfs *FS) error {
+		if b == nil {
+			return errors.New("backend can not be nil")
+		}
+
+		rfs.backend = b
+		return nil
+	}
+}
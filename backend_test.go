@@ -0,0 +1,67 @@
+package resonatefuse
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+// basicBackend only has what every backend has
+type basicBackend struct {
+	Backend
+	stats int
+}
+
+func (b *basicBackend) Stat(path string) (fuse.Attr, error) {
+	b.stats++
+	return b.Backend.Stat(path)
+}
+
+func TestBackendOption(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	assert.Nil(t, os.Mkdir(origin+"/joe", 0755))
+	backend := &basicBackend{Backend: NewLocalBackend(origin)}
+
+	// Volumes are populated from their backend
+	rfs, err := NewFS("elsewhere", BackendOption(backend))
+	assert.Nil(t, err)
+	assert.NotNil(t, rfs.root.Child("joe"))
+
+	ctx := context.Background()
+	node, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "leo", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("ali")}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	data, err := ioutil.ReadFile(origin + "/leo")
+	assert.Nil(t, err)
+	assert.Equal(t, "ali", string(data))
+
+	a := fuse.Attr{}
+	assert.Nil(t, node.(*File).Attr(ctx, &a))
+	assert.Equal(t, uint64(3), a.Size)
+	assert.Equal(t, 1, backend.stats)
+
+	// Operations the backend does not have fail without touching it
+	_, err = rfs.root.Mknod(ctx, &fuse.MknodRequest{Name: "pipe", Mode: os.ModeNamedPipe | 0644})
+	assert.Equal(t, fuse.Errno(syscall.EPERM), err)
+	err = node.(*File).Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.joe", Xattr: []byte("leo")})
+	assert.Equal(t, fuse.Errno(syscall.ENOTSUP), err)
+	err = node.(*File).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrUid, Uid: 1000}, &fuse.SetattrResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EPERM), err)
+
+	resp := &fuse.StatfsResponse{}
+	assert.Nil(t, rfs.Statfs(ctx, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(0), resp.Blocks)
+
+	_, err = NewFS(origin, BackendOption(nil))
+	assert.NotNil(t, err)
+}
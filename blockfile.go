@@ -0,0 +1,934 @@
+package resonatefuse
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/pkg/errors"
+)
+
+// blockSize is the size of the blocks file contents are encoded in
+const blockSize = 64 * 1024
+
+// blockCodec encodes the blocks of files before they reach the origin
+type blockCodec interface {
+	// flag identifies the codec in the header of files, codecs are applied
+	// in the order of their flags
+	flag() byte
+	// overhead is how much larger than a block its encoding may get
+	overhead() int
+	// authenticates reports whether decoding fails for blocks changed in
+	// the origin, blocks of files it encodes can not be left out as holes
+	authenticates() bool
+	// encode and decode a block, ad tells where the block belongs and starts
+	// with the id of its file (codecs that authenticate blocks bind them to it)
+	encode(block, ad []byte) ([]byte, error)
+	decode(stored, ad []byte) ([]byte, error)
+}
+
+// Every regular file in the origin is a header followed by a slot for each
+// block, a slot holds the length of the encoded block followed by the
+// encoded block, the rest of the slot is left unwritten so it takes no space
+// on file systems with sparse files, a slot of length 0 (or past the end of
+// the file in the origin) is a hole and reads as zeros unless a codec
+// authenticates blocks
+//
+// The header holds the flags of the codecs, the block size, an id unique to
+// the file and the size of the file with the ranges of blocks that are holes
+// whatever their slots hold, encoded like a block of its own, codecs that
+// authenticate blocks only leave holes the header lists
+var blockMagic = []byte("RSNTBLK1")
+
+const (
+	blockIDSize = 16
+	// blockHeaderIndex is the index the size of a file is encoded at
+	blockHeaderIndex = ^uint64(0)
+	// maxBlockHoles is how many ranges of holes the header of a file lists,
+	// the smallest is stored as blocks of zeros when there would be more
+	maxBlockHoles = 16
+)
+
+// blockRange is the blocks of a file from start up to end
+type blockRange struct {
+	start, end int64
+}
+
+type blockHeader struct {
+	flags     byte
+	blockSize int64
+	id        [blockIDSize]byte
+	size      int64
+	holes     []blockRange
+}
+
+// hole reports whether block i of the file is listed as a hole
+func (h *blockHeader) hole(i int64) bool {
+	for _, r := range h.holes {
+		if r.start <= i && i < r.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newBlockHeader returns the header of a new empty file
+func (b *blockBackend) newBlockHeader() (*blockHeader, error) {
+	h := &blockHeader{flags: b.flags, blockSize: b.blockSize}
+	if _, err := rand.Read(h.id[:]); err != nil {
+		return nil, errors.Wrapf(err, "could not create file id")
+	}
+
+	return h, nil
+}
+
+// ad returns what block i of the file is bound to
+func (h *blockHeader) ad(i uint64) []byte {
+	ad := make([]byte, blockIDSize+4+8)
+	copy(ad, h.id[:])
+	binary.LittleEndian.PutUint32(ad[blockIDSize:], uint32(h.blockSize))
+	binary.LittleEndian.PutUint64(ad[blockIDSize+4:], i)
+
+	return ad
+}
+
+// blockBackend stores files in a directory on the local disk with their
+// contents encoded block by block
+type blockBackend struct {
+	*LocalBackend
+
+	blockSize int64
+	codecs    []blockCodec
+	flags     byte
+	// sparse tells whether slots of length 0 are holes (no codec
+	// authenticates blocks)
+	sparse bool
+
+	// locks guard the contents of files by inode
+	locks *inodeLocks
+}
+
+// newBlockBackend creates a backend over the directory dir that encodes
+// contents with codecs, plain files found in dir are encoded as well
+func newBlockBackend(dir string, size int, codecs ...blockCodec) (*blockBackend, error) {
+	codecs = append([]blockCodec(nil), codecs...)
+	sort.Slice(codecs, func(i, j int) bool { return codecs[i].flag() < codecs[j].flag() })
+
+	b := &blockBackend{
+		LocalBackend: NewLocalBackend(dir),
+		blockSize:    int64(size),
+		codecs:       codecs,
+		sparse:       true,
+		locks:        newInodeLocks(),
+	}
+	for _, c := range codecs {
+		if b.flags&c.flag() != 0 {
+			return nil, errors.Errorf("could not encode contents twice the same way (%#x)", c.flag())
+		}
+		b.flags |= c.flag()
+		b.sparse = b.sparse && !c.authenticates()
+	}
+
+	if err := b.scan(); err != nil {
+		return nil, errors.Wrapf(err, "could not prepare files in %v", dir)
+	}
+
+	return b, nil
+}
+
+// scan encodes the plain files in the origin (hardlinks only once), files
+// encoded another way are logged and left alone
+func (b *blockBackend) scan() error {
+	seen := make(map[uint64]bool)
+	// imported holds where plain files with other names were imported to
+	imported := make(map[uint64]string)
+
+	return filepath.Walk(b.dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		// Left behind by imports that were interrupted
+		if importing(name) {
+			return os.Remove(name)
+		}
+
+		attr, err := fileAttr(info)
+		if err != nil {
+			return err
+		}
+		if seen[attr.Inode] {
+			if target, ok := imported[attr.Inode]; ok {
+				return relink(target, name)
+			}
+			return nil
+		}
+		seen[attr.Inode] = true
+
+		file, err := os.Open(name)
+		if err != nil {
+			return errors.Wrapf(err, "could not open %v", name)
+		}
+		defer file.Close()
+
+		// Files that can not be read are left as they are and fail with EIO
+		if _, err := b.readHeader(file); err != errNotBlockFile {
+			if err != nil {
+				log.Println(errors.Wrapf(err, "could not use %v", name))
+			}
+			return nil
+		}
+
+		if attr.Nlink > 1 {
+			imported[attr.Inode] = name
+		}
+		return b.importFile(file, info)
+	})
+}
+
+var errNotBlockFile = errors.New("file is not encoded in blocks")
+
+// importFile encodes the plain file next to it and then moves it in its
+// place (see replaceFile)
+func (b *blockBackend) importFile(file *os.File, info os.FileInfo) error {
+	log.Println("Encoding", file.Name())
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file.Name()), importPrefix)
+	if err != nil {
+		return errors.Wrapf(err, "could not import %v", file.Name())
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h, err := b.newBlockHeader()
+	if err != nil {
+		return err
+	}
+
+	if err := b.writeHeader(tmp, h); err != nil {
+		return err
+	}
+
+	f := &blockFile{backend: b, file: tmp, read: true, write: true}
+	if _, err := io.Copy(&blockWriter{f}, file); err != nil {
+		return errors.Wrapf(err, "could not import %v", file.Name())
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return errors.Wrapf(err, "could not import %v", file.Name())
+	}
+
+	return replaceFile(tmp.Name(), file.Name(), info)
+}
+
+// blockWriter appends to a block file
+type blockWriter struct {
+	f *blockFile
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	return w.f.writeAt(p, 0, true)
+}
+
+func (b *blockBackend) overhead() int64 {
+	overhead := 0
+	for _, c := range b.codecs {
+		overhead += c.overhead()
+	}
+
+	return int64(overhead)
+}
+
+func (b *blockBackend) slotSize(h *blockHeader) int64 {
+	return 4 + h.blockSize + b.overhead()
+}
+
+// headerSize is the size of the header of files, where their first slot starts
+func (b *blockBackend) headerSize() int64 {
+	return int64(len(blockMagic)) + 1 + 4 + blockIDSize + 4 + 8 + 1 + maxBlockHoles*16 + b.overhead()
+}
+
+// slotOffset returns where the slot of block i starts
+func (b *blockBackend) slotOffset(h *blockHeader, i int64) int64 {
+	return b.headerSize() + i*b.slotSize(h)
+}
+
+func (b *blockBackend) encode(block, ad []byte) ([]byte, error) {
+	var err error
+	for _, c := range b.codecs {
+		if block, err = c.encode(block, ad); err != nil {
+			return nil, err
+		}
+	}
+
+	return block, nil
+}
+
+func (b *blockBackend) decode(stored, ad []byte) ([]byte, error) {
+	var err error
+	for j := len(b.codecs) - 1; j >= 0; j-- {
+		if stored, err = b.codecs[j].decode(stored, ad); err != nil {
+			return nil, err
+		}
+	}
+
+	return stored, nil
+}
+
+func (b *blockBackend) readHeader(file *os.File) (*blockHeader, error) {
+	raw := make([]byte, b.headerSize())
+	n, err := file.ReadAt(raw, 0)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "could not read header")
+	}
+
+	if n < len(blockMagic) || !bytes.Equal(raw[:len(blockMagic)], blockMagic) {
+		return nil, errNotBlockFile
+	}
+
+	const fixed = 8 + 1 + 4 + blockIDSize + 4
+	if n < fixed {
+		return nil, errors.Wrapf(syscall.EIO, "could not read truncated header")
+	}
+
+	h := &blockHeader{
+		flags:     raw[8],
+		blockSize: int64(binary.LittleEndian.Uint32(raw[9:13])),
+	}
+	copy(h.id[:], raw[13:13+blockIDSize])
+
+	if h.flags != b.flags {
+		return nil, errors.Wrapf(syscall.EIO, "could not read file encoded with other options (%#x instead of %#x)", h.flags, b.flags)
+	}
+
+	length := int(binary.LittleEndian.Uint32(raw[fixed-4:]))
+	if h.blockSize < 1 || fixed+length > n {
+		return nil, errors.Wrapf(syscall.EIO, "could not read corrupt header")
+	}
+
+	sizes, err := b.decode(raw[fixed:fixed+length], h.ad(blockHeaderIndex))
+	if err != nil || len(sizes) < 8+1 || len(sizes) != 8+1+int(sizes[8])*16 {
+		return nil, errors.Wrapf(syscall.EIO, "could not decode header: %v", err)
+	}
+
+	h.size = int64(binary.LittleEndian.Uint64(sizes))
+	if h.size < 0 || sizes[8] > maxBlockHoles {
+		return nil, errors.Wrapf(syscall.EIO, "could not read corrupt header")
+	}
+
+	for j := 8 + 1; j < len(sizes); j += 16 {
+		r := blockRange{
+			start: int64(binary.LittleEndian.Uint64(sizes[j:])),
+			end:   int64(binary.LittleEndian.Uint64(sizes[j+8:])),
+		}
+		if r.start < 0 || r.end <= r.start || len(h.holes) > 0 && r.start < h.holes[len(h.holes)-1].end {
+			return nil, errors.Wrapf(syscall.EIO, "could not read corrupt header")
+		}
+		h.holes = append(h.holes, r)
+	}
+
+	return h, nil
+}
+
+func (b *blockBackend) writeHeader(file *os.File, h *blockHeader) error {
+	sizes := make([]byte, 8+1, 8+1+len(h.holes)*16)
+	binary.LittleEndian.PutUint64(sizes, uint64(h.size))
+	sizes[8] = byte(len(h.holes))
+	for _, r := range h.holes {
+		var raw [16]byte
+		binary.LittleEndian.PutUint64(raw[:], uint64(r.start))
+		binary.LittleEndian.PutUint64(raw[8:], uint64(r.end))
+		sizes = append(sizes, raw[:]...)
+	}
+
+	encoded, err := b.encode(sizes, h.ad(blockHeaderIndex))
+	if err != nil {
+		return errors.Wrapf(err, "could not encode header")
+	}
+
+	raw := make([]byte, 0, b.headerSize())
+	raw = append(raw, blockMagic...)
+	raw = append(raw, h.flags)
+	raw = append(raw, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(raw[9:13], uint32(h.blockSize))
+	raw = append(raw, h.id[:]...)
+	raw = append(raw, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(raw[len(raw)-4:], uint32(len(encoded)))
+	raw = append(raw, encoded...)
+
+	if int64(len(raw)) > b.headerSize() {
+		return errors.New("could not fit encoded header")
+	}
+
+	if _, err := file.WriteAt(raw, 0); err != nil {
+		return errors.Wrapf(err, "could not write header")
+	}
+
+	return nil
+}
+
+// readBlock returns block i of a file, it may be shorter than the block
+// size and any bytes missing are zeros (holes have none)
+func (b *blockBackend) readBlock(file *os.File, h *blockHeader, i int64) ([]byte, error) {
+	if h.hole(i) {
+		return nil, nil
+	}
+
+	slot := make([]byte, b.slotSize(h))
+	n, err := file.ReadAt(slot, b.slotOffset(h, i))
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "could not read block %v", i)
+	}
+
+	if n < 4 || binary.LittleEndian.Uint32(slot) == 0 {
+		if b.sparse {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(syscall.EIO, "could not read missing block %v", i)
+	}
+
+	length := int(binary.LittleEndian.Uint32(slot))
+	if 4+length > n {
+		return nil, errors.Wrapf(syscall.EIO, "could not read missing block %v", i)
+	}
+
+	block, err := b.decode(slot[4:4+length], h.ad(uint64(i)))
+	if err != nil {
+		return nil, errors.Wrapf(syscall.EIO, "could not decode block %v: %v", i, err)
+	}
+
+	if int64(len(block)) > h.blockSize {
+		return nil, errors.Wrapf(syscall.EIO, "could not read oversized block %v", i)
+	}
+
+	return block, nil
+}
+
+func (b *blockBackend) writeBlock(file *os.File, h *blockHeader, i int64, block []byte) error {
+	block, err := b.encode(block, h.ad(uint64(i)))
+	if err != nil {
+		return errors.Wrapf(err, "could not encode block %v", i)
+	}
+
+	size := b.slotSize(h)
+	if int64(4+len(block)) > size {
+		return errors.Errorf("could not fit encoded block %v in its slot", i)
+	}
+
+	slot := make([]byte, 4+len(block))
+	binary.LittleEndian.PutUint32(slot, uint32(len(block)))
+	copy(slot[4:], block)
+
+	off := b.slotOffset(h, i)
+	if _, err := file.WriteAt(slot, off); err != nil {
+		return errors.Wrapf(err, "could not write block %v", i)
+	}
+
+	// Blocks encoded shorter than before leave the rest of their slot unused
+	if tail := size - int64(len(slot)); tail > 0 {
+		if err := punchHole(file, off+int64(len(slot)), tail); err != nil {
+			return errors.Wrapf(err, "could not free the rest of block %v", i)
+		}
+	}
+
+	return nil
+}
+
+// addHole lists the blocks from start up to end of a file as holes, the
+// header still needs to be written
+func (b *blockBackend) addHole(file *os.File, h *blockHeader, start, end int64) error {
+	if start >= end {
+		return nil
+	}
+
+	if last := len(h.holes) - 1; last >= 0 && h.holes[last].end == start {
+		h.holes[last].end = end
+		return nil
+	}
+
+	h.holes = append(h.holes, blockRange{start, end})
+	return b.fitHoles(file, h)
+}
+
+// removeHole stops listing block i of a file as a hole before it is
+// written, the header still needs to be written
+func (b *blockBackend) removeHole(file *os.File, h *blockHeader, i int64) error {
+	for j, r := range h.holes {
+		if i < r.start || i >= r.end {
+			continue
+		}
+
+		var split []blockRange
+		if r.start < i {
+			split = append(split, blockRange{r.start, i})
+		}
+		if i+1 < r.end {
+			split = append(split, blockRange{i + 1, r.end})
+		}
+
+		h.holes = append(h.holes[:j], append(split, h.holes[j+1:]...)...)
+		return b.fitHoles(file, h)
+	}
+
+	return nil
+}
+
+// fitHoles stores the smallest ranges of holes as blocks of zeros until the
+// header can list the rest
+func (b *blockBackend) fitHoles(file *os.File, h *blockHeader) error {
+	for len(h.holes) > maxBlockHoles {
+		smallest := 0
+		for j, r := range h.holes {
+			if r.end-r.start < h.holes[smallest].end-h.holes[smallest].start {
+				smallest = j
+			}
+		}
+
+		r := h.holes[smallest]
+		for i := r.start; i < r.end; i++ {
+			if err := b.writeBlock(file, h, i, nil); err != nil {
+				return err
+			}
+		}
+		h.holes = append(h.holes[:smallest], h.holes[smallest+1:]...)
+	}
+
+	return nil
+}
+
+// blockCount returns the number of blocks of a file of size bytes
+func blockCount(h *blockHeader, size int64) int64 {
+	return (size + h.blockSize - 1) / h.blockSize
+}
+
+func (b *blockBackend) Stat(path string) (fuse.Attr, error) {
+	attr, err := b.LocalBackend.Stat(path)
+	if err != nil || !attr.Mode.IsRegular() {
+		return attr, err
+	}
+
+	file, err := os.Open(b.realify(path))
+	if err != nil {
+		return attr, err
+	}
+	defer file.Close()
+
+	ino, err := fileInode(file)
+	if err != nil {
+		return attr, err
+	}
+	defer b.locks.rlock(ino)()
+
+	h, err := b.readHeader(file)
+	if err != nil {
+		return attr, err
+	}
+	attr.Size = uint64(h.size)
+
+	return attr, nil
+}
+
+func (b *blockBackend) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := b.LocalBackend.ReadDir(path)
+	if err != nil {
+		return infos, err
+	}
+
+	visible := infos[:0]
+	for _, info := range infos {
+		if !importing(info.Name()) {
+			visible = append(visible, info)
+		}
+	}
+
+	return visible, nil
+}
+
+func (b *blockBackend) Open(path string, flags int) (BackendFile, error) {
+	access := os.O_RDONLY
+	if flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+		// Writing part of a block needs the block to be read as well
+		access = os.O_RDWR
+	}
+
+	file, err := os.OpenFile(b.realify(path), access|flags&os.O_SYNC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.openFile(file, flags)
+}
+
+// openFile makes file a block file used as flags ask (see Open), file needs
+// to be open for writing when flags are, it is closed if that fails
+func (b *blockBackend) openFile(file *os.File, flags int) (*blockFile, error) {
+	ino, err := fileInode(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &blockFile{
+		backend: b,
+		file:    file,
+		inode:   ino,
+		read:    flags&os.O_WRONLY == 0,
+		write:   flags&(os.O_WRONLY|os.O_RDWR) != 0,
+		append:  flags&os.O_APPEND != 0,
+	}
+
+	if flags&os.O_TRUNC != 0 && f.write {
+		unlock := b.locks.lock(ino)
+		err := b.truncate(file, 0)
+		unlock()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (b *blockBackend) Create(path string, mode os.FileMode, flags int) (BackendFile, error) {
+	if importing(path) {
+		return nil, reservedErr("create", path)
+	}
+
+	file, err := os.OpenFile(b.realify(path), os.O_RDWR|os.O_CREATE|flags&os.O_SYNC, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.initFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return b.openFile(file, flags)
+}
+
+// initFile gives a new file an empty header, existing files are left alone
+func (b *blockBackend) initFile(file *os.File) error {
+	ino, err := fileInode(file)
+	if err != nil {
+		return err
+	}
+	defer b.locks.lock(ino)()
+
+	if _, err := b.readHeader(file); err != errNotBlockFile {
+		return err
+	}
+
+	h, err := b.newBlockHeader()
+	if err != nil {
+		return err
+	}
+
+	return b.writeHeader(file, h)
+}
+
+func (b *blockBackend) Mkdir(path string, mode os.FileMode) error {
+	if importing(path) {
+		return reservedErr("mkdir", path)
+	}
+
+	return b.LocalBackend.Mkdir(path, mode)
+}
+
+func (b *blockBackend) Symlink(target, path string) error {
+	if importing(path) {
+		return reservedErr("symlink", path)
+	}
+
+	return b.LocalBackend.Symlink(target, path)
+}
+
+func (b *blockBackend) Mknod(path string, mode os.FileMode, rdev uint32) error {
+	if importing(path) {
+		return reservedErr("mknod", path)
+	}
+
+	return b.LocalBackend.Mknod(path, mode, rdev)
+}
+
+func (b *blockBackend) Link(oldPath, newPath string) error {
+	if importing(newPath) {
+		return reservedErr("link", newPath)
+	}
+
+	return b.LocalBackend.Link(oldPath, newPath)
+}
+
+func (b *blockBackend) Rename(oldPath, newPath string) error {
+	if importing(oldPath) {
+		return &os.PathError{Op: "rename", Path: oldPath, Err: syscall.ENOENT}
+	}
+	if importing(newPath) {
+		return reservedErr("rename", newPath)
+	}
+
+	return b.LocalBackend.Rename(oldPath, newPath)
+}
+
+func (b *blockBackend) Truncate(path string, size int64) error {
+	file, err := os.OpenFile(b.realify(path), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ino, err := fileInode(file)
+	if err != nil {
+		return err
+	}
+	defer b.locks.lock(ino)()
+
+	return b.truncate(file, size)
+}
+
+// truncate changes the size of the file
+func (b *blockBackend) truncate(file *os.File, size int64) error {
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: file.Name(), Err: syscall.EINVAL}
+	}
+
+	h, err := b.readHeader(file)
+	if err != nil {
+		return err
+	}
+
+	if size > h.size {
+		f := &blockFile{backend: b, file: file, write: true}
+		_, err := f.fill(h, size)
+		return err
+	}
+
+	kept := blockCount(h, size)
+	if rest := size % h.blockSize; rest != 0 {
+		block, err := b.readBlock(file, h, kept-1)
+		if err != nil {
+			return err
+		}
+
+		if int64(len(block)) > rest {
+			if err := b.writeBlock(file, h, kept-1, block[:rest]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := file.Truncate(b.slotOffset(h, kept)); err != nil {
+		return errors.Wrapf(err, "could not truncate file")
+	}
+
+	holes := h.holes[:0]
+	for _, r := range h.holes {
+		if r.start < kept {
+			holes = append(holes, blockRange{r.start, min64(r.end, kept)})
+		}
+	}
+	h.holes = holes
+
+	h.size = size
+	return b.writeHeader(file, h)
+}
+
+// blockFile is a regular file opened in a block backend
+type blockFile struct {
+	backend *blockBackend
+	file    *os.File
+	inode   uint64
+	read    bool
+	write   bool
+	append  bool
+}
+
+func (f *blockFile) ReadAt(p []byte, off int64) (int, error) {
+	if !f.read {
+		return 0, &os.PathError{Op: "read", Path: f.file.Name(), Err: syscall.EBADF}
+	}
+
+	b := f.backend
+	defer b.locks.rlock(f.inode)()
+
+	h, err := b.readHeader(f.file)
+	if err != nil {
+		return 0, err
+	}
+
+	if off >= h.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > h.size {
+		end = h.size
+	}
+
+	n := 0
+	for pos := off; pos < end; {
+		i := pos / h.blockSize
+		start := pos - i*h.blockSize
+
+		block, err := b.readBlock(f.file, h, i)
+		if err != nil {
+			return n, err
+		}
+
+		segment := p[n : n+int(min64(h.blockSize-start, end-pos))]
+		copied := 0
+		if start < int64(len(block)) {
+			copied = copy(segment, block[start:])
+		}
+		for j := copied; j < len(segment); j++ {
+			segment[j] = 0
+		}
+
+		n += len(segment)
+		pos += int64(len(segment))
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *blockFile) WriteAt(p []byte, off int64) (int, error) {
+	if !f.write {
+		return 0, &os.PathError{Op: "write", Path: f.file.Name(), Err: syscall.EBADF}
+	}
+
+	defer f.backend.locks.lock(f.inode)()
+
+	return f.writeAt(p, off, f.append)
+}
+
+func (f *blockFile) Write(p []byte) (int, error) {
+	if !f.write {
+		return 0, &os.PathError{Op: "write", Path: f.file.Name(), Err: syscall.EBADF}
+	}
+
+	defer f.backend.locks.lock(f.inode)()
+
+	return f.writeAt(p, 0, true)
+}
+
+// writeAt rewrites the blocks covered by p at off (or the end of the file)
+func (f *blockFile) writeAt(p []byte, off int64, atEnd bool) (int, error) {
+	b := f.backend
+	h, err := b.readHeader(f.file)
+	if err != nil {
+		return 0, err
+	}
+
+	if atEnd {
+		off = h.size
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Blocks between the end of the file and off are stored as zeros
+	if off > h.size {
+		if h, err = f.fill(h, off); err != nil {
+			return 0, err
+		}
+	}
+
+	end := off + int64(len(p))
+	n := 0
+	holed := false
+	for pos := off; pos < end; {
+		i := pos / h.blockSize
+		start := pos - i*h.blockSize
+
+		// The block keeps what it had around the part being written
+		var block []byte
+		if i < blockCount(h, h.size) {
+			if block, err = b.readBlock(f.file, h, i); err != nil {
+				return n, err
+			}
+		}
+
+		length := min64(h.blockSize, maxInt64(end, h.size)-i*h.blockSize)
+		updated := make([]byte, length)
+		copy(updated, block)
+		written := copy(updated[start:], p[n:])
+
+		if h.hole(i) {
+			holed = true
+			if err := b.removeHole(f.file, h, i); err != nil {
+				return n, err
+			}
+		}
+
+		if err := b.writeBlock(f.file, h, i, updated); err != nil {
+			return n, err
+		}
+
+		n += written
+		pos += int64(written)
+	}
+
+	// The header only lists the blocks written as holes no more once they
+	// are written
+	if end > h.size || holed {
+		h.size = maxInt64(end, h.size)
+		if err := b.writeHeader(f.file, h); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// fill grows the file to size with zeros, the last block already reads as
+// zeros past its end and new blocks are holes (listed in the header when
+// codecs authenticate blocks)
+func (f *blockFile) fill(h *blockHeader, size int64) (*blockHeader, error) {
+	b := f.backend
+
+	if !b.sparse {
+		if err := b.addHole(f.file, h, blockCount(h, h.size), blockCount(h, size)); err != nil {
+			return h, err
+		}
+	}
+
+	h.size = size
+	return h, b.writeHeader(f.file, h)
+}
+
+func (f *blockFile) Sync() error {
+	return f.file.Sync()
+}
+
+func (f *blockFile) Close() error {
+	return f.file.Close()
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+var _ Backend = (*blockBackend)(nil)
+var _ BackendSyncer = (*blockBackend)(nil)
+var _ BackendChowner = (*blockBackend)(nil)
+var _ BackendMknoder = (*blockBackend)(nil)
+var _ BackendXattrer = (*blockBackend)(nil)
+var _ BackendStatfser = (*blockBackend)(nil)
@@ -0,0 +1,88 @@
+package resonatefuse
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// Ways a compressed block is stored
+const (
+	blockStored     byte = 0
+	blockCompressed byte = 1
+)
+
+// deflateCodec compresses blocks with deflate, blocks that do not shrink
+// are stored as they are
+type deflateCodec struct {
+	level int
+}
+
+func (c *deflateCodec) flag() byte {
+	return 1
+}
+
+func (c *deflateCodec) overhead() int {
+	return 1
+}
+
+func (c *deflateCodec) authenticates() bool {
+	return false
+}
+
+func (c *deflateCodec) encode(block, ad []byte) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{blockCompressed})
+	w, err := flate.NewWriter(buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(block); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() > len(block) {
+		return append([]byte{blockStored}, block...), nil
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *deflateCodec) decode(stored, ad []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, errors.New("empty block")
+	}
+
+	switch stored[0] {
+	case blockStored:
+		return stored[1:], nil
+	case blockCompressed:
+		// A block never decompresses past the block size
+		r := flate.NewReader(bytes.NewReader(stored[1:]))
+		defer r.Close()
+		return ioutil.ReadAll(io.LimitReader(r, blockSize+1))
+	default:
+		return nil, errors.Errorf("unknown block encoding (%v)", stored[0])
+	}
+}
+
+// Compress stores the contents of files in the origin compressed block by
+// block, files read and written through the volume (and hooks) see them as
+// they are, plain files already in the origin are compressed (level is one
+// of the levels of compress/flate)
+func Compress(level int) Option {
+	return func(rfs *FS) error {
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return errors.Errorf("unknown compression level (%v)", level)
+		}
+
+		rfs.codecs = append(rfs.codecs, &deflateCodec{level: level})
+		return nil
+	}
+}
@@ -0,0 +1,170 @@
+package resonatefuse
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompress(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	// Plain files already in the origin are compressed
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(origin, "joe"), []byte("leo"), 0640))
+
+	var writes [][]byte
+	record := func(req *GeneralRequest) error {
+		writes = append(writes, req.Data)
+		return nil
+	}
+
+	rfs, err := NewFS(origin, Compress(flate.DefaultCompression), GeneralOption(WriteType, record))
+	assert.Nil(t, err)
+	assert.Equal(t, "leo", string(readFile(t, rfs.root.Child("joe"))))
+
+	info, err := os.Stat(filepath.Join(origin, "joe"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode())
+
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("resonate "), 20000)
+	node := writeFile(t, rfs.root, "ali", data)
+	assert.Equal(t, [][]byte{data}, writes)
+
+	a := fuse.Attr{}
+	assert.Nil(t, node.Attr(ctx, &a))
+	assert.Equal(t, uint64(len(data)), a.Size)
+
+	// The origin only holds the compressed blocks
+	raw, err := ioutil.ReadFile(filepath.Join(origin, "ali"))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(raw, []byte("resonate resonate")))
+	b := rfs.Backend().(*blockBackend)
+	assert.True(t, binary.LittleEndian.Uint32(raw[b.headerSize():]) < blockSize/10)
+
+	content, err := readAll(node)
+	assert.Nil(t, err)
+	assert.Equal(t, data, content)
+
+	// Writing at an offset past the end leaves zeros in between
+	h, err := node.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("joe"), Offset: 3}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("leo"), Offset: int64(len(data) + blockSize)}, &fuse.WriteResponse{}))
+
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, 16)}
+	assert.Nil(t, h.(*Handle).Read(ctx, &fuse.ReadRequest{Size: 16}, resp))
+	assert.Equal(t, "resjoete resonat", string(resp.Data))
+	assert.Nil(t, h.(*Handle).Read(ctx, &fuse.ReadRequest{Offset: int64(len(data) + blockSize - 2), Size: 16}, resp))
+	assert.Equal(t, "\x00\x00leo", string(resp.Data))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	setattr := &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 5}
+	assert.Nil(t, node.Setattr(ctx, setattr, &fuse.SetattrResponse{}))
+	assert.Equal(t, "resjo", string(readFile(t, node)))
+
+	setattr.Size = 8
+	assert.Nil(t, node.Setattr(ctx, setattr, &fuse.SetattrResponse{}))
+	assert.Equal(t, "resjo\x00\x00\x00", string(readFile(t, node)))
+
+	// Growing leaves holes instead of storing blocks of zeros
+	before, err := os.Stat(filepath.Join(origin, "ali"))
+	assert.Nil(t, err)
+	setattr.Size = 10 * blockSize
+	assert.Nil(t, node.Setattr(ctx, setattr, &fuse.SetattrResponse{}))
+	after, err := os.Stat(filepath.Join(origin, "ali"))
+	assert.Nil(t, err)
+	assert.Equal(t, before.Size(), after.Size())
+	content, err = readAll(node)
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte("resjo"), make([]byte, 10*blockSize-5)...), content)
+
+	// Files are only read with the options they were written with, others
+	// are left alone and fail with EIO
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(origin, "raw"), append(append([]byte{}, blockMagic...), make([]byte, 64)...), 0644))
+	again, err := NewFS(origin, Compress(flate.DefaultCompression))
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.EIO, again.root.Child("raw").Attr(ctx, &a))
+	h, err = again.root.Child("raw").Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.EIO, h.(*Handle).Read(ctx, &fuse.ReadRequest{Size: 16}, &fuse.ReadResponse{Data: make([]byte, 0, 16)}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+	assert.Equal(t, "resjo", string(readFile(t, again.root.Child("ali"))[:5]))
+
+	_, err = NewFS(origin, Compress(42))
+	assert.NotNil(t, err)
+	_, err = NewFS(origin, Compress(flate.DefaultCompression), BackendOption(NewMemoryBackend()))
+	assert.NotNil(t, err)
+}
+
+func TestCompressImport(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	joe, leo := filepath.Join(origin, "joe"), filepath.Join(origin, "leo")
+	assert.Nil(t, ioutil.WriteFile(joe, []byte("ali"), 0644))
+	assert.Nil(t, os.Link(joe, leo))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(origin, importPrefix+"123"), []byte("muhammad"), 0644))
+
+	rfs, err := NewFS(origin, Compress(flate.DefaultCompression))
+	assert.Nil(t, err)
+
+	// Hardlinks stay linked
+	joeInfo, err := os.Stat(joe)
+	assert.Nil(t, err)
+	leoInfo, err := os.Stat(leo)
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(joeInfo, leoInfo))
+	assert.Equal(t, "ali", string(readFile(t, rfs.root.Child("leo"))))
+
+	// Interrupted imports are removed and their names can not be used
+	assert.Nil(t, rfs.root.Child(importPrefix+"123"))
+	_, err = os.Stat(filepath.Join(origin, importPrefix+"123"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = rfs.Backend().Create(importPrefix+"456", 0644, os.O_RDWR)
+	assert.True(t, os.IsExist(err))
+}
+
+func TestCompressPunchHole(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	rfs, err := NewFS(origin, Compress(flate.DefaultCompression))
+	assert.Nil(t, err)
+	b := rfs.Backend()
+
+	data := make([]byte, 3*blockSize)
+	_, err = rand.Read(data)
+	assert.Nil(t, err)
+	f, err := b.Create("joe", 0644, os.O_RDWR)
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = f.WriteAt(data, 0)
+	assert.Nil(t, err)
+
+	blocks := func() int64 {
+		info, err := os.Stat(filepath.Join(origin, "joe"))
+		assert.Nil(t, err)
+		return info.Sys().(*syscall.Stat_t).Blocks
+	}
+	before := blocks()
+
+	// Blocks encoded shorter free the rest of their slot
+	_, err = f.WriteAt(bytes.Repeat([]byte("resonate "), blockSize/9), blockSize)
+	assert.Nil(t, err)
+	assert.True(t, blocks() <= before-blockSize/2/512, "blocks %v before %v", blocks(), before)
+}
@@ -0,0 +1,1228 @@
+package resonatefuse
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/pkg/errors"
+)
+
+// ChunkDir is the directory in the origin of a deduplicating volume that
+// holds the chunks, it is hidden from the volume
+const ChunkDir = ".resonate-chunks"
+
+// DefaultChunkSize is a chunk size that suits most volumes
+const DefaultChunkSize = 64 * 1024
+
+// A manifest replaces the contents of every regular file in the origin, it
+// is made of a header followed by the hash of every chunk of the file (an
+// all zero hash being a chunk that only holds zeros)
+var manifestMagic = []byte("RSNTDDP1")
+
+const manifestHeaderSize = 8 + 4 + 8
+
+type chunkHash [sha256.Size]byte
+
+func (h chunkHash) hole() bool {
+	return h == chunkHash{}
+}
+
+type manifestHeader struct {
+	chunkSize int64
+	size      int64
+}
+
+type chunkInfo struct {
+	refs int
+	size int64
+}
+
+// DedupStats describes how much the chunks of a deduplicating backend are shared
+type DedupStats struct {
+	// Chunks is the number of distinct chunks stored
+	Chunks int
+	// References is the number of times chunks are used by files
+	References int
+	// StoredBytes is the size of the distinct chunks
+	StoredBytes int64
+	// ReferencedBytes is the size of the chunks as used by files, it is what
+	// would be stored without deduplication (ignoring sparse zeros)
+	ReferencedBytes int64
+}
+
+// DedupBackend stores files in a directory on the local disk with their
+// contents split into chunks that are stored once by their hash under
+// ChunkDir, chunks are shared between files and reference counted
+//
+// Everything but the contents of regular files (modes, times, owners,
+// xattrs, links and special files) is kept by the files themselves
+type DedupBackend struct {
+	*LocalBackend
+
+	chunkSize int64
+
+	// mu guards the chunks and the open files, it is taken after the lock
+	// of a manifest
+	mu     sync.RWMutex
+	chunks map[chunkHash]*chunkInfo
+	open   map[uint64]*openManifest
+
+	// locks guard the manifests by inode
+	locks *inodeLocks
+}
+
+// openManifest counts the open files of a manifest (by inode), a manifest
+// whose last name is gone keeps its chunks until its last file is closed
+type openManifest struct {
+	files    int
+	unlinked bool
+}
+
+// NewDedupBackend creates a deduplicating backend over the directory dir
+// splitting new files into chunks of chunkSize bytes, plain files found in
+// dir are turned into deduplicated files in place
+func NewDedupBackend(dir string, chunkSize int) (*DedupBackend, error) {
+	if chunkSize < 1 {
+		return nil, errors.Errorf("chunks need to be at least one byte (got %v)", chunkSize)
+	}
+
+	b := &DedupBackend{
+		LocalBackend: NewLocalBackend(dir),
+		chunkSize:    int64(chunkSize),
+		chunks:       make(map[chunkHash]*chunkInfo),
+		open:         make(map[uint64]*openManifest),
+		locks:        newInodeLocks(),
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, ChunkDir), 0700); err != nil {
+		return nil, errors.Wrapf(err, "could not create chunk store in %v", dir)
+	}
+
+	if err := b.scan(); err != nil {
+		return nil, errors.Wrapf(err, "could not count chunks in %v", dir)
+	}
+
+	return b, nil
+}
+
+// Deduplicate stores the files of the volume deduplicated in the origin (see NewDedupBackend)
+func Deduplicate(chunkSize int) Option {
+	return func(rfs *FS) error {
+		b, err := NewDedupBackend(rfs.origin, chunkSize)
+		if err != nil {
+			return err
+		}
+
+		rfs.backend = b
+		return nil
+	}
+}
+
+// Stats returns how much the chunks are shared
+func (b *DedupBackend) Stats() DedupStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := DedupStats{Chunks: len(b.chunks)}
+	for _, info := range b.chunks {
+		stats.References += info.refs
+		stats.StoredBytes += info.size
+		stats.ReferencedBytes += int64(info.refs) * info.size
+	}
+
+	return stats
+}
+
+// scan counts the references to chunks from every file (hardlinks only once),
+// imports plain files and removes chunks nothing refers to
+func (b *DedupBackend) scan() error {
+	seen := make(map[uint64]bool)
+	// imported holds where plain files with other names were imported to
+	imported := make(map[uint64]string)
+	store := filepath.Join(b.dir, ChunkDir)
+
+	err := filepath.Walk(b.dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if name == store {
+			return filepath.SkipDir
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		// Left behind by imports that were interrupted
+		if importing(name) {
+			return os.Remove(name)
+		}
+
+		attr, err := fileAttr(info)
+		if err != nil {
+			return err
+		}
+		if seen[attr.Inode] {
+			if manifest, ok := imported[attr.Inode]; ok {
+				return relink(manifest, name)
+			}
+			return nil
+		}
+		seen[attr.Inode] = true
+
+		file, err := os.Open(name)
+		if err != nil {
+			return errors.Wrapf(err, "could not open %v", name)
+		}
+		defer file.Close()
+
+		hashes, err := b.hashes(file)
+		if err == errNotManifest {
+			if attr.Nlink > 1 {
+				imported[attr.Inode] = name
+			}
+			return b.importFile(file, info)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not read manifest %v", name)
+		}
+
+		for _, h := range hashes {
+			if !h.hole() {
+				b.ref(h, 0)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(store, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		// Left behind by chunks that were being written
+		if filepath.Ext(name) == ".tmp" {
+			return os.Remove(name)
+		}
+
+		raw, err := hex.DecodeString(info.Name())
+		if err != nil || len(raw) != sha256.Size {
+			return nil
+		}
+
+		var h chunkHash
+		copy(h[:], raw)
+		if chunk, ok := b.chunks[h]; ok {
+			chunk.size = info.Size()
+			return nil
+		}
+
+		// Left behind by files removed while the volume was not running
+		return os.Remove(name)
+	})
+}
+
+var errNotManifest = errors.New("file is not a manifest")
+
+// importFile turns the plain file into a deduplicated file, its chunks and
+// manifest are durable before the manifest replaces the file
+func (b *DedupBackend) importFile(file *os.File, info os.FileInfo) error {
+	log.Println("Deduplicating", file.Name())
+
+	hashes := make([]chunkHash, 0)
+	var size int64
+	pending := make([]string, 0)
+
+	buf := make([]byte, b.chunkSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			h, created, err := b.put(buf[:n])
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, h)
+			if created {
+				pending = append(pending, b.chunkPath(h))
+			}
+			size += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not read %v", file.Name())
+		}
+	}
+
+	if err := syncChunks(pending); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file.Name()), importPrefix)
+	if err != nil {
+		return errors.Wrapf(err, "could not import %v", file.Name())
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	m := &manifest{file: tmp, header: manifestHeader{chunkSize: b.chunkSize, size: size}}
+	if err := m.writeHeader(); err != nil {
+		return err
+	}
+
+	for i, h := range hashes {
+		if err := m.setHash(int64(i), h); err != nil {
+			return err
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return errors.Wrapf(err, "could not import %v", file.Name())
+	}
+
+	return replaceFile(tmp.Name(), file.Name(), info)
+}
+
+func (b *DedupBackend) chunkPath(h chunkHash) string {
+	name := hex.EncodeToString(h[:])
+	return filepath.Join(b.dir, ChunkDir, name[:2], name)
+}
+
+// ref adds a reference to the chunk h of size bytes
+func (b *DedupBackend) ref(h chunkHash, size int64) {
+	chunk, ok := b.chunks[h]
+	if !ok {
+		chunk = &chunkInfo{size: size}
+		b.chunks[h] = chunk
+	}
+
+	chunk.refs++
+}
+
+// unref drops a reference to the chunk h, removing it once nothing refers to it
+func (b *DedupBackend) unref(h chunkHash) {
+	if b.drop(h) {
+		b.remove([]chunkHash{h})
+	}
+}
+
+// drop drops a reference to the chunk h and reports whether nothing refers
+// to it anymore, the chunk is left in the store for remove
+func (b *DedupBackend) drop(h chunkHash) bool {
+	if h.hole() {
+		return false
+	}
+
+	chunk, ok := b.chunks[h]
+	if !ok {
+		return false
+	}
+
+	chunk.refs--
+	if chunk.refs > 0 {
+		return false
+	}
+
+	delete(b.chunks, h)
+	return true
+}
+
+// dropUnused drops a reference to the chunk h and adds it to unused once
+// nothing refers to it (mu is not held)
+func (b *DedupBackend) dropUnused(unused []chunkHash, h chunkHash) []chunkHash {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.drop(h) {
+		unused = append(unused, h)
+	}
+
+	return unused
+}
+
+// remove removes the dropped chunks hashes from the store, chunks stored
+// again since they were dropped are kept
+func (b *DedupBackend) remove(hashes []chunkHash) {
+	for _, h := range hashes {
+		if _, ok := b.chunks[h]; ok {
+			continue
+		}
+
+		if err := os.Remove(b.chunkPath(h)); err != nil && !os.IsNotExist(err) {
+			log.Println(errors.Wrapf(err, "could not remove unused chunk"))
+		}
+	}
+}
+
+// put stores data as a chunk with a reference to it, created tells whether
+// the chunk was not stored before (mu is not held, chunks are written
+// without it)
+func (b *DedupBackend) put(data []byte) (h chunkHash, created bool, err error) {
+	if allZero(data) {
+		return chunkHash{}, false, nil
+	}
+
+	h = sha256.Sum256(data)
+	b.mu.Lock()
+	if _, ok := b.chunks[h]; ok {
+		b.ref(h, int64(len(data)))
+		b.mu.Unlock()
+		return h, false, nil
+	}
+	b.mu.Unlock()
+
+	tmp, err := writeChunk(b.chunkPath(h), data)
+	if err != nil {
+		return h, false, err
+	}
+	defer os.Remove(tmp)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Chunks are moved into the store with mu held so remove can not see
+	// them unreferenced
+	if _, ok := b.chunks[h]; !ok {
+		if err := os.Rename(tmp, b.chunkPath(h)); err != nil {
+			return h, false, errors.Wrapf(err, "could not store chunk")
+		}
+		created = true
+	}
+	b.ref(h, int64(len(data)))
+
+	return h, created, nil
+}
+
+// chunk returns the contents of the chunk h
+func (b *DedupBackend) chunk(h chunkHash) ([]byte, error) {
+	if h.hole() {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(b.chunkPath(h))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read chunk")
+	}
+
+	return data, nil
+}
+
+// writeChunk writes data next to the chunk name and returns the file it is
+// in, chunks are renamed into place so they appear whole or not at all
+func writeChunk(name string, data []byte) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return "", errors.Wrapf(err, "could not create chunk directory")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return "", errors.Wrapf(err, "could not write chunk")
+	}
+	defer tmp.Close()
+
+	if _, err := tmp.Write(data); err != nil {
+		os.Remove(tmp.Name())
+		return "", errors.Wrapf(err, "could not write chunk")
+	}
+
+	return tmp.Name(), nil
+}
+
+// syncChunks commits the chunks names and their directories to stable storage
+func syncChunks(names []string) error {
+	dirs := make(map[string]bool)
+	for _, name := range names {
+		if err := syncPath(name); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return err
+		}
+		dirs[filepath.Dir(name)] = true
+	}
+
+	for dir := range dirs {
+		if err := syncPath(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func allZero(data []byte) bool {
+	for _, c := range data {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// manifest is the manifest of a regular file
+type manifest struct {
+	file   *os.File
+	header manifestHeader
+}
+
+func (b *DedupBackend) readManifest(file *os.File) (*manifest, error) {
+	raw := make([]byte, manifestHeaderSize)
+	n, err := file.ReadAt(raw, 0)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "could not read manifest")
+	}
+
+	if n < manifestHeaderSize || !bytes.Equal(raw[:len(manifestMagic)], manifestMagic) {
+		return nil, errNotManifest
+	}
+
+	header := manifestHeader{
+		chunkSize: int64(binary.LittleEndian.Uint32(raw[8:12])),
+		size:      int64(binary.LittleEndian.Uint64(raw[12:20])),
+	}
+	if header.chunkSize < 1 || header.size < 0 {
+		return nil, errors.Wrapf(syscall.EIO, "could not read corrupt manifest")
+	}
+
+	return &manifest{file: file, header: header}, nil
+}
+
+// hashes returns the hashes of all chunks of the file
+func (b *DedupBackend) hashes(file *os.File) ([]chunkHash, error) {
+	m, err := b.readManifest(file)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]chunkHash, m.count())
+	for i := range hashes {
+		if hashes[i], err = m.hash(int64(i)); err != nil {
+			return nil, err
+		}
+	}
+
+	return hashes, nil
+}
+
+// count returns the number of chunks of the file
+func (m *manifest) count() int64 {
+	return (m.header.size + m.header.chunkSize - 1) / m.header.chunkSize
+}
+
+func (m *manifest) writeHeader() error {
+	raw := make([]byte, manifestHeaderSize)
+	copy(raw, manifestMagic)
+	binary.LittleEndian.PutUint32(raw[8:12], uint32(m.header.chunkSize))
+	binary.LittleEndian.PutUint64(raw[12:20], uint64(m.header.size))
+
+	if _, err := m.file.WriteAt(raw, 0); err != nil {
+		return errors.Wrapf(err, "could not write manifest")
+	}
+
+	return nil
+}
+
+// hash returns the hash of chunk i, chunks past the manifest are holes
+func (m *manifest) hash(i int64) (chunkHash, error) {
+	var h chunkHash
+	_, err := m.file.ReadAt(h[:], manifestHeaderSize+i*sha256.Size)
+	if err == io.EOF {
+		return chunkHash{}, nil
+	}
+	if err != nil {
+		return h, errors.Wrapf(err, "could not read manifest")
+	}
+
+	return h, nil
+}
+
+func (m *manifest) setHash(i int64, h chunkHash) error {
+	if _, err := m.file.WriteAt(h[:], manifestHeaderSize+i*sha256.Size); err != nil {
+		return errors.Wrapf(err, "could not write manifest")
+	}
+
+	return nil
+}
+
+// chunkLen returns the length of chunk i in a file of size bytes
+func (m *manifest) chunkLen(i, size int64) int64 {
+	if rest := size - i*m.header.chunkSize; rest < m.header.chunkSize {
+		return rest
+	}
+
+	return m.header.chunkSize
+}
+
+// reserved reports whether path is the chunk store or a file being imported
+func reserved(path string) bool {
+	return filepath.Clean(path) == ChunkDir || importing(path)
+}
+
+func reservedErr(op, path string) error {
+	return &os.PathError{Op: op, Path: path, Err: syscall.EEXIST}
+}
+
+func (b *DedupBackend) Stat(path string) (fuse.Attr, error) {
+	attr, err := b.LocalBackend.Stat(path)
+	if err != nil || !attr.Mode.IsRegular() {
+		return attr, err
+	}
+
+	file, err := os.Open(b.realify(path))
+	if err != nil {
+		return attr, err
+	}
+	defer file.Close()
+
+	ino, err := fileInode(file)
+	if err != nil {
+		return attr, err
+	}
+	defer b.locks.rlock(ino)()
+
+	m, err := b.readManifest(file)
+	if err != nil {
+		return attr, err
+	}
+	attr.Size = uint64(m.header.size)
+
+	return attr, nil
+}
+
+func (b *DedupBackend) ReadDir(path string) ([]os.FileInfo, error) {
+	infos, err := b.LocalBackend.ReadDir(path)
+	if err != nil {
+		return infos, err
+	}
+
+	visible := infos[:0]
+	for _, info := range infos {
+		if !reserved(filepath.Join(path, info.Name())) {
+			visible = append(visible, info)
+		}
+	}
+
+	return visible, nil
+}
+
+func (b *DedupBackend) Open(path string, flags int) (BackendFile, error) {
+	access := os.O_RDONLY
+	if flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+		// Writing needs the manifest to be read as well
+		access = os.O_RDWR
+	}
+
+	// Files are opened with the lock held so they count as open before their
+	// last name can be removed
+	b.mu.Lock()
+	file, err := os.OpenFile(b.realify(path), access|flags&os.O_SYNC, 0)
+	var f *dedupFile
+	if err == nil {
+		f, err = b.openFile(file, flags)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.prepare(f, false, flags)
+}
+
+// openFile makes file a deduplicated file used as flags ask (see Open) and
+// counts it as open, file needs to be open for writing when flags are and is
+// closed if that fails (mu is held)
+func (b *DedupBackend) openFile(file *os.File, flags int) (*dedupFile, error) {
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	attr, err := fileAttr(info)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &dedupFile{
+		backend: b,
+		file:    file,
+		inode:   attr.Inode,
+		read:    flags&os.O_WRONLY == 0,
+		write:   flags&(os.O_WRONLY|os.O_RDWR) != 0,
+		append:  flags&os.O_APPEND != 0,
+	}
+
+	open, ok := b.open[f.inode]
+	if !ok {
+		open = &openManifest{}
+		b.open[f.inode] = open
+	}
+	open.files++
+
+	return f, nil
+}
+
+// prepare gives a created file an empty manifest and empties a file opened
+// for writing with O_TRUNC, f is closed if that fails (mu is not held)
+func (b *DedupBackend) prepare(f *dedupFile, create bool, flags int) (BackendFile, error) {
+	unlock := b.locks.lock(f.inode)
+	var err error
+	if create {
+		err = b.initFile(f.file)
+	}
+	if err == nil && flags&os.O_TRUNC != 0 && f.write {
+		f.unused, err = b.truncate(f.file, 0)
+	}
+	unlock()
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (b *DedupBackend) Create(path string, mode os.FileMode, flags int) (BackendFile, error) {
+	if reserved(path) {
+		return nil, reservedErr("create", path)
+	}
+
+	b.mu.Lock()
+	file, err := os.OpenFile(b.realify(path), os.O_RDWR|os.O_CREATE|flags&os.O_SYNC, mode)
+	var f *dedupFile
+	if err == nil {
+		f, err = b.openFile(file, flags)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.prepare(f, true, flags)
+}
+
+// initFile gives a new file an empty manifest, existing files are left alone
+// (the manifest is locked)
+func (b *DedupBackend) initFile(file *os.File) error {
+	if _, err := b.readManifest(file); err != errNotManifest {
+		return err
+	}
+
+	m := &manifest{file: file, header: manifestHeader{chunkSize: b.chunkSize}}
+	return m.writeHeader()
+}
+
+func (b *DedupBackend) Mkdir(path string, mode os.FileMode) error {
+	if reserved(path) {
+		return reservedErr("mkdir", path)
+	}
+
+	return b.LocalBackend.Mkdir(path, mode)
+}
+
+func (b *DedupBackend) Symlink(target, path string) error {
+	if reserved(path) {
+		return reservedErr("symlink", path)
+	}
+
+	return b.LocalBackend.Symlink(target, path)
+}
+
+func (b *DedupBackend) Mknod(path string, mode os.FileMode, rdev uint32) error {
+	if reserved(path) {
+		return reservedErr("mknod", path)
+	}
+
+	return b.LocalBackend.Mknod(path, mode, rdev)
+}
+
+// Link makes another name for the manifest, the chunks are referred to
+// once by the manifest however many names it has
+func (b *DedupBackend) Link(oldPath, newPath string) error {
+	if reserved(newPath) {
+		return reservedErr("link", newPath)
+	}
+
+	return b.LocalBackend.Link(oldPath, newPath)
+}
+
+// release returns the chunks of the file at path when path is its last
+// name, they are unreferenced once done is called after path is gone (or
+// once the file is closed when it is open)
+func (b *DedupBackend) release(path string) (done func(), err error) {
+	noop := func() {}
+
+	info, err := os.Lstat(b.realify(path))
+	if os.IsNotExist(err) {
+		return noop, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	attr, err := fileAttr(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if !attr.Mode.IsRegular() || attr.Nlink > 1 {
+		return noop, nil
+	}
+
+	if open, ok := b.open[attr.Inode]; ok {
+		return func() { open.unlinked = true }, nil
+	}
+
+	file, err := os.Open(b.realify(path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hashes, err := b.hashes(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		for _, h := range hashes {
+			b.unref(h)
+		}
+	}, nil
+}
+
+// lockPath locks the manifest at path when path is a regular file, path is
+// looked up again once locked in case it was replaced in the meantime
+func (b *DedupBackend) lockPath(path string) (unlock func()) {
+	for {
+		info, err := os.Lstat(b.realify(path))
+		if err != nil || !info.Mode().IsRegular() {
+			return func() {}
+		}
+
+		attr, err := fileAttr(info)
+		if err != nil {
+			return func() {}
+		}
+
+		unlock := b.locks.lock(attr.Inode)
+		again, err := os.Lstat(b.realify(path))
+		if err == nil && os.SameFile(info, again) {
+			return unlock
+		}
+		unlock()
+	}
+}
+
+func (b *DedupBackend) Remove(path string) error {
+	defer b.lockPath(path)()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	done, err := b.release(path)
+	if err != nil {
+		return err
+	}
+
+	if err := b.LocalBackend.Remove(path); err != nil {
+		return err
+	}
+	done()
+
+	return nil
+}
+
+func (b *DedupBackend) Rename(oldPath, newPath string) error {
+	if reserved(oldPath) {
+		return &os.PathError{Op: "rename", Path: oldPath, Err: syscall.ENOENT}
+	}
+	if reserved(newPath) {
+		return reservedErr("rename", newPath)
+	}
+
+	defer b.lockPath(newPath)()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Renaming a name of a file onto another name of it changes nothing
+	oldInfo, err := os.Lstat(b.realify(oldPath))
+	if err != nil {
+		return err
+	}
+	newInfo, err := os.Lstat(b.realify(newPath))
+	if err == nil && os.SameFile(oldInfo, newInfo) {
+		return b.LocalBackend.Rename(oldPath, newPath)
+	}
+
+	// The file replaced by the rename gives up its chunks
+	done, err := b.release(newPath)
+	if err != nil {
+		return err
+	}
+
+	if err := b.LocalBackend.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	done()
+
+	return nil
+}
+
+func (b *DedupBackend) Truncate(path string, size int64) error {
+	file, err := os.OpenFile(b.realify(path), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ino, err := fileInode(file)
+	if err != nil {
+		return err
+	}
+	defer b.locks.lock(ino)()
+
+	unused, err := b.truncate(file, size)
+	if err != nil {
+		return err
+	}
+
+	// Chunks the manifest may still refer to after a crash are left for scan
+	if err := file.Sync(); err != nil {
+		log.Println(errors.Wrapf(err, "could not commit truncated manifest %v", path))
+		return nil
+	}
+
+	b.mu.Lock()
+	b.remove(unused)
+	b.mu.Unlock()
+
+	return nil
+}
+
+// truncate changes the size of the file with the manifest file, the chunks
+// it no longer refers to are returned for remove once the manifest is durable
+// (the manifest is locked)
+func (b *DedupBackend) truncate(file *os.File, size int64) (unused []chunkHash, err error) {
+	if size < 0 {
+		return nil, &os.PathError{Op: "truncate", Path: file.Name(), Err: syscall.EINVAL}
+	}
+
+	m, err := b.readManifest(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < m.header.size {
+		cs := m.header.chunkSize
+		kept := (size + cs - 1) / cs
+
+		// The last chunk kept is cut at the new size
+		if last := kept - 1; last >= 0 && size%cs != 0 {
+			old, err := m.hash(last)
+			if err != nil {
+				return unused, err
+			}
+
+			data, err := b.chunk(old)
+			if err != nil {
+				return unused, err
+			}
+
+			if int64(len(data)) > size%cs {
+				h, _, err := b.put(data[:size%cs])
+				if err != nil {
+					return unused, err
+				}
+				if err := m.setHash(last, h); err != nil {
+					return unused, err
+				}
+				unused = b.dropUnused(unused, old)
+			}
+		}
+
+		for i := kept; i < m.count(); i++ {
+			h, err := m.hash(i)
+			if err != nil {
+				return unused, err
+			}
+			unused = b.dropUnused(unused, h)
+		}
+
+		if err := file.Truncate(manifestHeaderSize + kept*sha256.Size); err != nil {
+			return unused, errors.Wrapf(err, "could not truncate manifest")
+		}
+	}
+
+	// Growing only needs the size, chunks past the manifest are holes
+	m.header.size = size
+	return unused, m.writeHeader()
+}
+
+// dedupFile is a regular file opened in a deduplicating backend
+type dedupFile struct {
+	backend *DedupBackend
+	file    *os.File
+	inode   uint64
+	read    bool
+	write   bool
+	append  bool
+
+	// pending are the chunks written through the file since it was last
+	// synced, they and unused are guarded by the lock of the manifest
+	pending []string
+	// unused are the chunks the file stopped referring to since it was last
+	// synced, they are removed once the manifest is durable
+	unused []chunkHash
+}
+
+func (f *dedupFile) ReadAt(p []byte, off int64) (int, error) {
+	if !f.read {
+		return 0, &os.PathError{Op: "read", Path: f.file.Name(), Err: syscall.EBADF}
+	}
+
+	b := f.backend
+	defer b.locks.rlock(f.inode)()
+
+	m, err := b.readManifest(f.file)
+	if err != nil {
+		return 0, err
+	}
+
+	if off >= m.header.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > m.header.size {
+		end = m.header.size
+	}
+
+	cs := m.header.chunkSize
+	n := 0
+	for pos := off; pos < end; {
+		i := pos / cs
+		start := pos - i*cs
+		h, err := m.hash(i)
+		if err != nil {
+			return n, err
+		}
+
+		data, err := b.chunk(h)
+		if err != nil {
+			return n, err
+		}
+
+		// Chunks are zero extended up to the size of the file
+		segment := p[n : n+int(min64(cs-start, end-pos))]
+		copied := 0
+		if start < int64(len(data)) {
+			copied = copy(segment, data[start:])
+		}
+		for j := copied; j < len(segment); j++ {
+			segment[j] = 0
+		}
+
+		n += len(segment)
+		pos += int64(len(segment))
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *dedupFile) WriteAt(p []byte, off int64) (int, error) {
+	if !f.write {
+		return 0, &os.PathError{Op: "write", Path: f.file.Name(), Err: syscall.EBADF}
+	}
+
+	defer f.backend.locks.lock(f.inode)()
+
+	return f.writeAt(p, off, f.append)
+}
+
+func (f *dedupFile) Write(p []byte) (int, error) {
+	if !f.write {
+		return 0, &os.PathError{Op: "write", Path: f.file.Name(), Err: syscall.EBADF}
+	}
+
+	defer f.backend.locks.lock(f.inode)()
+
+	return f.writeAt(p, 0, true)
+}
+
+// writeAt replaces the chunks covered by p at off (or the end of the file)
+func (f *dedupFile) writeAt(p []byte, off int64, atEnd bool) (int, error) {
+	b := f.backend
+	m, err := b.readManifest(f.file)
+	if err != nil {
+		return 0, err
+	}
+
+	if atEnd {
+		off = m.header.size
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	end := off + int64(len(p))
+	size := m.header.size
+	if end > size {
+		size = end
+	}
+
+	cs := m.header.chunkSize
+	n := 0
+	for pos := off; pos < end; {
+		i := pos / cs
+		start := pos - i*cs
+
+		old, err := m.hash(i)
+		if err != nil {
+			return n, err
+		}
+
+		data, err := b.chunk(old)
+		if err != nil {
+			return n, err
+		}
+
+		// The chunk keeps what it had around the part being written
+		chunk := make([]byte, m.chunkLen(i, size))
+		copy(chunk, data)
+		written := copy(chunk[start:], p[n:])
+
+		h, created, err := b.put(chunk)
+		if err != nil {
+			return n, err
+		}
+		if created {
+			f.pending = append(f.pending, b.chunkPath(h))
+		}
+		if err := m.setHash(i, h); err != nil {
+			b.mu.Lock()
+			b.unref(h)
+			b.mu.Unlock()
+			return n, err
+		}
+		f.unused = b.dropUnused(f.unused, old)
+
+		n += written
+		pos += int64(written)
+	}
+
+	if size != m.header.size {
+		m.header.size = size
+		if err := m.writeHeader(); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Sync commits the chunks written through the file and then its manifest,
+// the chunks it stopped referring to are removed afterwards
+func (f *dedupFile) Sync() error {
+	b := f.backend
+	unlock := b.locks.lock(f.inode)
+	pending, unused := f.pending, f.unused
+	f.pending, f.unused = nil, nil
+	unlock()
+
+	if err := syncChunks(pending); err != nil {
+		f.requeue(unused)
+		return err
+	}
+
+	if err := f.file.Sync(); err != nil {
+		f.requeue(unused)
+		return err
+	}
+
+	b.mu.Lock()
+	b.remove(unused)
+	b.mu.Unlock()
+
+	return nil
+}
+
+// requeue gives chunks back to the file to be removed by a later sync
+func (f *dedupFile) requeue(unused []chunkHash) {
+	defer f.backend.locks.lock(f.inode)()
+	f.unused = append(f.unused, unused...)
+}
+
+// Close closes the file, the chunks of a manifest that lost its last name
+// are given up with its last file
+func (f *dedupFile) Close() error {
+	b := f.backend
+	unlock := b.locks.rlock(f.inode)
+	unused := len(f.unused) > 0
+	unlock()
+
+	if unused {
+		// Chunks the manifest may still refer to after a crash are left for scan
+		if err := f.Sync(); err != nil {
+			log.Println(errors.Wrapf(err, "could not commit manifest %v", f.file.Name()))
+		}
+	}
+
+	defer b.locks.lock(f.inode)()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if open := b.open[f.inode]; open != nil {
+		open.files--
+		if open.files == 0 {
+			delete(b.open, f.inode)
+
+			if open.unlinked {
+				hashes, err := b.hashes(f.file)
+				if err != nil {
+					log.Println(errors.Wrapf(err, "could not give up chunks of removed file"))
+				}
+				for _, h := range hashes {
+					b.unref(h)
+				}
+			}
+		}
+	}
+
+	return f.file.Close()
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+var _ Backend = (*DedupBackend)(nil)
+var _ BackendSyncer = (*DedupBackend)(nil)
+var _ BackendChowner = (*DedupBackend)(nil)
+var _ BackendMknoder = (*DedupBackend)(nil)
+var _ BackendXattrer = (*DedupBackend)(nil)
+var _ BackendStatfser = (*DedupBackend)(nil)
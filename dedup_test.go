@@ -0,0 +1,223 @@
+package resonatefuse
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, dir *File, name string, data []byte) *File {
+	ctx := context.Background()
+	node, h, err := dir.Create(ctx, &fuse.CreateRequest{Name: name, Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: data}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	return node.(*File)
+}
+
+func readFile(t *testing.T, node *File) []byte {
+	data, err := readAll(node)
+	assert.Nil(t, err)
+
+	return data
+}
+
+// readAll reads the whole file through a handle like the kernel would
+func readAll(node *File) ([]byte, error) {
+	ctx := context.Background()
+	h, err := node.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	if err != nil {
+		return nil, err
+	}
+	defer h.(*Handle).Release(ctx, &fuse.ReleaseRequest{})
+
+	var data []byte
+	for {
+		resp := &fuse.ReadResponse{Data: make([]byte, 0, 64*1024)}
+		req := &fuse.ReadRequest{Offset: int64(len(data)), Size: cap(resp.Data)}
+		if err := h.(*Handle).Read(ctx, req, resp); err != nil {
+			return data, err
+		}
+
+		data = append(data, resp.Data...)
+		if len(resp.Data) < req.Size {
+			return data, nil
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	// Plain files already in the origin are deduplicated
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(origin, "joe"), []byte("aaaabbbbcc"), 0644))
+
+	rfs, err := NewFS(origin, Deduplicate(4))
+	assert.Nil(t, err)
+	b := rfs.Backend().(*DedupBackend)
+	assert.Equal(t, DedupStats{Chunks: 3, References: 3, StoredBytes: 10, ReferencedBytes: 10}, b.Stats())
+	assert.Nil(t, rfs.root.Child(ChunkDir))
+
+	ctx := context.Background()
+	leo := writeFile(t, rfs.root, "leo", []byte("bbbbaaaa"))
+	assert.Equal(t, DedupStats{Chunks: 3, References: 5, StoredBytes: 10, ReferencedBytes: 18}, b.Stats())
+	assert.Equal(t, "bbbbaaaa", string(readFile(t, leo)))
+
+	a := fuse.Attr{}
+	assert.Nil(t, leo.Attr(ctx, &a))
+	assert.Equal(t, uint64(8), a.Size)
+
+	// Writing in the middle of a file only replaces the chunks written
+	h, err := leo.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("cc"), Offset: 3}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("z"), Offset: 13}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Sync())
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+	assert.Equal(t, "bbbccaaa\x00\x00\x00\x00\x00z", string(readFile(t, leo)))
+
+	// Hardlinks share the chunks of their file
+	before := b.Stats()
+	_, err = rfs.root.Link(ctx, &fuse.LinkRequest{NewName: "ali"}, leo)
+	assert.Nil(t, err)
+	assert.Equal(t, before, b.Stats())
+	assert.Nil(t, rfs.root.Remove(ctx, &fuse.RemoveRequest{Name: "leo"}))
+	assert.Equal(t, before, b.Stats())
+
+	// Renaming over a file gives up the chunks of the file replaced
+	writeFile(t, rfs.root, "muhammad", []byte("dddd"))
+	assert.Nil(t, rfs.root.Rename(ctx, &fuse.RenameRequest{OldName: "ali", NewName: "muhammad"}, rfs.root))
+	assert.Equal(t, before, b.Stats())
+
+	setattr := &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 2}
+	assert.Nil(t, rfs.root.Child("muhammad").Setattr(ctx, setattr, &fuse.SetattrResponse{}))
+	assert.Equal(t, "bb", string(readFile(t, rfs.root.Child("muhammad"))))
+
+	// Counting again from the origin gives the same
+	stats := b.Stats()
+	again, err := NewFS(origin, Deduplicate(4))
+	assert.Nil(t, err)
+	assert.Equal(t, stats, again.Backend().(*DedupBackend).Stats())
+
+	assert.Nil(t, rfs.root.Remove(ctx, &fuse.RemoveRequest{Name: "joe"}))
+	assert.Nil(t, rfs.root.Remove(ctx, &fuse.RemoveRequest{Name: "muhammad"}))
+	assert.Equal(t, DedupStats{}, b.Stats())
+
+	chunks := 0
+	filepath.Walk(filepath.Join(origin, ChunkDir), func(name string, info os.FileInfo, err error) error {
+		if !info.IsDir() {
+			chunks++
+		}
+		return nil
+	})
+	assert.Equal(t, 0, chunks)
+
+	_, err = NewFS(origin, Deduplicate(0))
+	assert.NotNil(t, err)
+}
+
+func TestDedupOpenRemoved(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	rfs, err := NewFS(origin, Deduplicate(4))
+	assert.Nil(t, err)
+	b := rfs.Backend().(*DedupBackend)
+
+	// Removed files keep their chunks while they are open
+	ctx := context.Background()
+	joe := writeFile(t, rfs.root, "joe", []byte("aaaabbbb"))
+	h, err := joe.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, rfs.root.Remove(ctx, &fuse.RemoveRequest{Name: "joe"}))
+	assert.Equal(t, DedupStats{Chunks: 2, References: 2, StoredBytes: 8, ReferencedBytes: 8}, b.Stats())
+
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("cccc"), Offset: 4}, &fuse.WriteResponse{}))
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, 16)}
+	assert.Nil(t, h.(*Handle).Read(ctx, &fuse.ReadRequest{Size: 16}, resp))
+	assert.Equal(t, "aaaacccc", string(resp.Data))
+
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+	assert.Equal(t, DedupStats{}, b.Stats())
+}
+
+func TestDedupImport(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	joe, leo := filepath.Join(origin, "joe"), filepath.Join(origin, "leo")
+	assert.Nil(t, ioutil.WriteFile(joe, []byte("aaaabbbb"), 0640))
+	assert.Nil(t, os.Link(joe, leo))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(origin, importPrefix+"123"), []byte("cccc"), 0644))
+
+	rfs, err := NewFS(origin, Deduplicate(4))
+	assert.Nil(t, err)
+	assert.Equal(t, DedupStats{Chunks: 2, References: 2, StoredBytes: 8, ReferencedBytes: 8}, rfs.Backend().(*DedupBackend).Stats())
+
+	// Hardlinks stay linked and keep their mode
+	joeInfo, err := os.Stat(joe)
+	assert.Nil(t, err)
+	leoInfo, err := os.Stat(leo)
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(joeInfo, leoInfo))
+	assert.Equal(t, os.FileMode(0640), joeInfo.Mode())
+	assert.Equal(t, "aaaabbbb", string(readFile(t, rfs.root.Child("leo"))))
+
+	// Interrupted imports are removed
+	assert.Nil(t, rfs.root.Child(importPrefix+"123"))
+	_, err = os.Stat(filepath.Join(origin, importPrefix+"123"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDedupRemoveAfterSync(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	b, err := NewDedupBackend(origin, 4)
+	assert.Nil(t, err)
+	exists := func(data string) bool {
+		_, err := os.Stat(b.chunkPath(sha256.Sum256([]byte(data))))
+		return err == nil
+	}
+
+	f, err := b.Create("joe", 0644, os.O_RDWR)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("aaaa"), 0)
+	assert.Nil(t, err)
+
+	// Chunks replaced stay in the store until the manifest is synced
+	_, err = f.WriteAt([]byte("bbbb"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, DedupStats{Chunks: 1, References: 1, StoredBytes: 4, ReferencedBytes: 4}, b.Stats())
+	assert.True(t, exists("aaaa"))
+	assert.Nil(t, f.Sync())
+	assert.False(t, exists("aaaa"))
+
+	// Chunks stored again before the sync are kept
+	_, err = f.WriteAt([]byte("cccc"), 0)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("bbbb"), 4)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("dddd"), 0)
+	assert.Nil(t, err)
+	assert.True(t, exists("bbbb") && exists("cccc"))
+	assert.Nil(t, f.Close())
+	assert.True(t, exists("bbbb"))
+	assert.False(t, exists("cccc"))
+
+	assert.Nil(t, b.Truncate("joe", 2))
+	assert.False(t, exists("bbbb") || exists("dddd"))
+	assert.True(t, exists("dd"))
+}
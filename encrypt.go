@@ -0,0 +1,151 @@
+package resonatefuse
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// aeadCodec encrypts and authenticates blocks with AES-GCM, every file has
+// its own key derived from the key and the id of the file with HKDF and
+// every block gets a random nonce, blocks are bound to their file and
+// position so they can not be changed, moved or swapped without reads failing
+//
+// NOTE: Putting back an older version of a whole file or of one of its
+// blocks is not detected
+type aeadCodec struct {
+	key []byte
+}
+
+// gcmOverhead is how much larger than a block AES-GCM makes it (the nonce
+// and the tag)
+const gcmOverhead = 12 + 16
+
+// fileKeyInfo binds the keys of files to what they are used for
+var fileKeyInfo = []byte("resonatefuse block key")
+
+func newAEADCodec(key []byte) (*aeadCodec, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, errors.Wrapf(err, "could not use encryption key")
+	}
+
+	return &aeadCodec{key: append([]byte(nil), key...)}, nil
+}
+
+// fileAEAD returns the cipher of the file the block bound to ad belongs to,
+// ad starts with the id of the file (see blockHeader.ad)
+func (c *aeadCodec) fileAEAD(ad []byte) (cipher.AEAD, error) {
+	if len(ad) < blockIDSize {
+		return nil, errors.New("block not bound to a file")
+	}
+
+	key, err := fileKey(c.key, ad[:blockIDSize], len(c.key))
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// fileKey derives the key of the file with the id (size bytes) from secret
+// with HKDF-SHA256 and no salt (RFC 5869)
+func fileKey(secret, id []byte, size int) ([]byte, error) {
+	info := append(append([]byte(nil), fileKeyInfo...), id...)
+	key := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), key); err != nil {
+		return nil, errors.Wrapf(err, "could not derive file key")
+	}
+
+	return key, nil
+}
+
+func (c *aeadCodec) flag() byte {
+	return 2
+}
+
+func (c *aeadCodec) overhead() int {
+	return gcmOverhead
+}
+
+func (c *aeadCodec) authenticates() bool {
+	return true
+}
+
+func (c *aeadCodec) encode(block, ad []byte) ([]byte, error) {
+	aead, err := c.fileAEAD(ad)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(block)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrapf(err, "could not create nonce")
+	}
+
+	return aead.Seal(nonce, nonce, block, ad), nil
+}
+
+func (c *aeadCodec) decode(stored, ad []byte) ([]byte, error) {
+	aead, err := c.fileAEAD(ad)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stored) < aead.NonceSize() {
+		return nil, errors.New("block too short")
+	}
+
+	nonce, sealed := stored[:aead.NonceSize()], stored[aead.NonceSize():]
+	block, err := aead.Open(nil, nonce, sealed, ad)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not authenticate block")
+	}
+
+	return block, nil
+}
+
+// EncryptionKey stores the contents of files in the origin encrypted block
+// by block with key (16, 24 or 32 bytes for AES-128, AES-192 or AES-256),
+// files read and written through the volume (and hooks) see them as they
+// are, plain files already in the origin are encrypted
+//
+// Blocks changed in the origin fail reads with EIO, names, sizes of files
+// in the origin and everything besides contents are not encrypted
+func EncryptionKey(key []byte) Option {
+	return EncryptionKeyFunc(func() ([]byte, error) {
+		return key, nil
+	})
+}
+
+// EncryptionKeyFunc is EncryptionKey with the key returned by f, it is
+// called once when the volume is created
+func EncryptionKeyFunc(f func() ([]byte, error)) Option {
+	return func(rfs *FS) error {
+		if f == nil {
+			return errors.New("encryption key function can not be nil")
+		}
+
+		key, err := f()
+		if err != nil {
+			return errors.Wrapf(err, "could not get encryption key")
+		}
+
+		codec, err := newAEADCodec(key)
+		if err != nil {
+			return err
+		}
+
+		rfs.codecs = append(rfs.codecs, codec)
+		return nil
+	}
+}
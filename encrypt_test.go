@@ -0,0 +1,187 @@
+package resonatefuse
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncrypt(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	key := bytes.Repeat([]byte{42}, 32)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(origin, "joe"), []byte("secret joe"), 0644))
+
+	var writes []string
+	record := func(req *GeneralRequest) error {
+		writes = append(writes, string(req.Data))
+		return nil
+	}
+
+	rfs, err := NewFS(origin, EncryptionKey(key), Compress(flate.BestSpeed), GeneralOption(WriteType, record))
+	assert.Nil(t, err)
+	assert.Equal(t, "secret joe", string(readFile(t, rfs.root.Child("joe"))))
+
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("secret leo "), 10000)
+	leo := writeFile(t, rfs.root, "leo", data)
+	ali := writeFile(t, rfs.root, "ali", data)
+	assert.Equal(t, []string{string(data), string(data)}, writes)
+
+	a := fuse.Attr{}
+	assert.Nil(t, leo.Attr(ctx, &a))
+	assert.Equal(t, uint64(len(data)), a.Size)
+
+	content, err := readAll(leo)
+	assert.Nil(t, err)
+	assert.Equal(t, data, content)
+
+	for _, name := range []string{"joe", "leo"} {
+		raw, err := ioutil.ReadFile(filepath.Join(origin, name))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(raw, []byte("secret")))
+	}
+
+	// A block changed in the origin fails reads
+	b := rfs.Backend().(*blockBackend)
+	leoName := filepath.Join(origin, "leo")
+	raw, err := ioutil.ReadFile(leoName)
+	assert.Nil(t, err)
+	raw[b.headerSize()+10] ^= 1
+	assert.Nil(t, ioutil.WriteFile(leoName, raw, 0644))
+
+	h, err := leo.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.EIO, h.(*Handle).Read(ctx, &fuse.ReadRequest{Size: 16}, &fuse.ReadResponse{Data: make([]byte, 0, 16)}))
+	assert.Nil(t, h.(*Handle).Read(ctx, &fuse.ReadRequest{Offset: blockSize, Size: 16}, &fuse.ReadResponse{Data: make([]byte, 0, 16)}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	// So do blocks moved from another file or position
+	aliName := filepath.Join(origin, "ali")
+	other, err := ioutil.ReadFile(aliName)
+	assert.Nil(t, err)
+	slot := b.slotSize(&blockHeader{blockSize: blockSize})
+	copy(other[b.headerSize():], other[b.headerSize()+slot:])
+	assert.Nil(t, ioutil.WriteFile(aliName, other, 0644))
+	_, err = readAll(ali)
+	assert.Equal(t, fuse.EIO, err)
+
+	raw[20] ^= 1
+	assert.Nil(t, ioutil.WriteFile(leoName, raw, 0644))
+	assert.Equal(t, fuse.EIO, leo.Attr(ctx, &a))
+
+	// Files are only read with the key and options they were written with
+	assert.Nil(t, os.Remove(leoName))
+	assert.Nil(t, os.Remove(aliName))
+	rfs, err = NewFS(origin, EncryptionKey(bytes.Repeat([]byte{7}, 32)), Compress(flate.BestSpeed))
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.EIO, rfs.root.Child("joe").Attr(ctx, &a))
+	rfs, err = NewFS(origin, EncryptionKey(key))
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.EIO, rfs.root.Child("joe").Attr(ctx, &a))
+	rfs, err = NewFS(origin, EncryptionKeyFunc(func() ([]byte, error) { return key, nil }), Compress(flate.BestSpeed))
+	assert.Nil(t, err)
+	assert.Equal(t, "secret joe", string(readFile(t, rfs.root.Child("joe"))))
+
+	_, err = NewFS(origin, EncryptionKey([]byte("short")))
+	assert.NotNil(t, err)
+	_, err = NewFS(origin, EncryptionKeyFunc(func() ([]byte, error) { return nil, errors.New("no key") }))
+	assert.NotNil(t, err)
+	_, err = NewFS(origin, EncryptionKey(key), EncryptionKey(key))
+	assert.NotNil(t, err)
+}
+
+func TestHKDF(t *testing.T) {
+	// Keys of files are HKDF-SHA256 with no salt (RFC 5869), they must not
+	// change or files encrypted before can no longer be read
+	id := make([]byte, blockIDSize)
+	for i := range id {
+		id[i] = byte(i)
+	}
+	key, err := fileKey(bytes.Repeat([]byte{42}, 16), id, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, "c36ff3038159ab9cc29ab2c588e455a2", hex.EncodeToString(key))
+	key, err = fileKey(bytes.Repeat([]byte{7}, 32), bytes.Repeat([]byte{0xff}, blockIDSize), 32)
+	assert.Nil(t, err)
+	assert.Equal(t, "0d44d97a4ca4d89a5be4b572197000464baa7a879c9c738b129e55c0e914c6ce", hex.EncodeToString(key))
+
+	// Blocks only open for the file they were sealed for
+	c, err := newAEADCodec(bytes.Repeat([]byte{42}, 16))
+	assert.Nil(t, err)
+	ad := make([]byte, blockIDSize)
+	sealed, err := c.encode([]byte("joe"), ad)
+	assert.Nil(t, err)
+	block, err := c.decode(sealed, ad)
+	assert.Nil(t, err)
+	assert.Equal(t, "joe", string(block))
+
+	ad[0] = 1
+	_, err = c.decode(sealed, ad)
+	assert.NotNil(t, err)
+}
+
+func TestEncryptHoles(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	rfs, err := NewFS(origin, EncryptionKey(bytes.Repeat([]byte{42}, 32)))
+	assert.Nil(t, err)
+	b := rfs.Backend().(*blockBackend)
+
+	// Growing lists the new blocks as holes instead of storing them
+	ctx := context.Background()
+	node := writeFile(t, rfs.root, "joe", []byte("leo"))
+	name := filepath.Join(origin, "joe")
+	before, err := os.Stat(name)
+	assert.Nil(t, err)
+	setattr := &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 40 * blockSize}
+	assert.Nil(t, node.Setattr(ctx, setattr, &fuse.SetattrResponse{}))
+	after, err := os.Stat(name)
+	assert.Nil(t, err)
+	assert.Equal(t, before.Size(), after.Size())
+
+	// Writing every other block splits the holes past what the header lists
+	h, err := node.Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	expected := append([]byte("leo"), make([]byte, 40*blockSize-3)...)
+	for i := int64(2); i < 40; i += 2 {
+		assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("ali"), Offset: i * blockSize}, &fuse.WriteResponse{}))
+		copy(expected[i*blockSize:], "ali")
+	}
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	content, err := readAll(node)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, content)
+
+	file, err := os.Open(name)
+	assert.Nil(t, err)
+	header, err := b.readHeader(file)
+	assert.Nil(t, err)
+	assert.Len(t, header.holes, maxBlockHoles)
+
+	// Blocks not listed as holes can not be turned into holes in the origin
+	i := int64(0)
+	for header.hole(i) || i == 0 {
+		i++
+	}
+	file.Close()
+	raw, err := ioutil.ReadFile(name)
+	assert.Nil(t, err)
+	copy(raw[b.slotOffset(header, i):], []byte{0, 0, 0, 0})
+	assert.Nil(t, ioutil.WriteFile(name, raw, 0644))
+	_, err = readAll(node)
+	assert.Equal(t, fuse.EIO, err)
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"bazil.org/fuse"
@@ -47,6 +48,19 @@ func (f *FFile) Path() string {
 	return f.node.Path()
 }
 
+// Attr returns the attributes of the file in the backend
+func (f *FFile) Attr() (fuse.Attr, error) {
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	attr, err := f.fs.backend.Stat(f.Path())
+	if err != nil {
+		return fuse.Attr{}, errors.Wrapf(err, "could not retrieve file (%v) info", f.Path())
+	}
+
+	return attr, nil
+}
+
 // ReadDirAll returns all children
 func (f *FFile) ReadDirAll() ([]fuse.Dirent, error) {
 	log.Println("ReadDirAlling", f.Name())
@@ -55,7 +69,7 @@ func (f *FFile) ReadDirAll() ([]fuse.Dirent, error) {
 
 // Lookup returns info about child
 func (f *FFile) Lookup(name string) (*FFile, error) {
-	log.Println("Looking for", name, "in", f.node.name)
+	log.Println("Looking for", name, "in", f.Name())
 	child := f.Child(name)
 	if child == nil {
 		log.Println("lookup faild")
@@ -65,32 +79,46 @@ func (f *FFile) Lookup(name string) (*FFile, error) {
 	return child, nil
 }
 
-// Create creats a new file on disk and filetree
-func (f *FFile) Create(name string, mode os.FileMode) (*FFile, error) {
-	log.Println("Creating", name, "in", f.node.name)
+// Create creats a new file on disk and filetree and opens it with flags (see Open)
+func (f *FFile) Create(name string, mode os.FileMode, flags int) (*FFile, BackendFile, error) {
+	log.Println("Creating", name, "in", f.Name())
+
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
 
-	if err := Touch(f.fs.realify(filepath.Join(f.Path(), name)), mode); err != nil {
-		return nil, errors.Wrapf(err, "could not add file %v to disk", name)
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
+	file, err := f.fs.backend.Create(filepath.Join(f.Path(), name), mode, flags&openFlags)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "could not add file %v to disk", name)
 	}
 
 	if err := f.node.CreateChild(name); err != nil {
-		return nil, errors.Wrapf(err, "could not add file %v to filetree", name)
+		file.Close()
+		return nil, nil, errors.Wrapf(err, "could not add file %v to filetree", name)
 	}
 
-	return f.Child(name), nil
+	return f.Child(name), file, nil
 }
 
 // Remove removes file from disk and filetree
 func (f *FFile) Remove(name string) error {
-	log.Println("Removing", name, "in", f.node.name)
+	log.Println("Removing", name, "in", f.Name())
 	// First remove the file from the tree then remove it from disk (order is important)
 
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
 	child := f.Child(name)
 	if child == nil {
 		return errors.Errorf("could not remove file (%v) as it does was not found", name)
 	}
 
-	if child.Type() == DIR && len(child.node.children) > 0 {
+	if child.Type() == DIR && len(child.node.Children()) > 0 {
 		return errors.Errorf("could not remove directory (%v) as it is not empty", name)
 	}
 
@@ -98,37 +126,53 @@ func (f *FFile) Remove(name string) error {
 		return errors.Wrapf(err, "could not remove file %v from filetree", name)
 	}
 
-	if err := rm(f.fs.realify(filepath.Join(f.Path(), name))); err != nil {
+	if err := f.fs.backend.Remove(filepath.Join(f.Path(), name)); err != nil {
 		return errors.Wrapf(err, "could not remove file %v from disk", name)
 	}
 
 	return nil
 }
 
-func (f *FFile) Write(data []byte, offset int64) (int, error) {
-	log.Println("Writing", f.node.name)
+// Open opens the file in the backend, only the access mode, append, truncate and sync flags are used
+func (f *FFile) Open(flags int) (BackendFile, error) {
+	log.Println("Opening", f.Name())
+
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
 
-	n, err := writeAt(f.fs.realify(f.Path()), data, offset)
+	file, err := f.fs.backend.Open(f.Path(), flags&openFlags)
 	if err != nil {
-		log.Println(err)
-		return n, errors.Wrapf(err, "could not write data to file (%v)", f.node.name)
+		return nil, errors.Wrapf(err, "could not open file (%v)", f.Name())
 	}
 
-	return n, nil
+	return file, nil
 }
 
-// ReadAll returns all bytes in file
-func (f *FFile) ReadAll() ([]byte, error) {
-	log.Println("ReadAlling", f.node.name)
-	return readall(f.fs.realify(f.Path()))
-}
+// Sync commits the file to stable storage, directories are synced along
+// with their parent so the directory entry itself is durable (only on
+// backends that can sync files that are not open)
+func (f *FFile) Sync() error {
+	log.Println("Syncing", f.Name())
 
-func (f *FFile) Read(data []byte, offset int64) error {
-	log.Println("Reading", f.node.name)
-	_, err := readAt(f.fs.realify(f.Path()), data, offset)
-	if err != nil {
-		log.Println(err)
-		return errors.Wrapf(err, "could not read data from file (%v)", f.node.name)
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	syncer, ok := f.fs.backend.(BackendSyncer)
+	if !ok {
+		return nil
+	}
+
+	f.node.data.RLock()
+	defer f.node.data.RUnlock()
+
+	if err := syncer.Sync(f.Path()); err != nil {
+		return errors.Wrapf(err, "could not sync file (%v)", f.Name())
+	}
+
+	if f.Type() == DIR {
+		if err := syncer.Sync(filepath.Dir(f.Path())); err != nil {
+			return errors.Wrapf(err, "could not sync parent of directory (%v)", f.Name())
+		}
 	}
 
 	return nil
@@ -141,20 +185,32 @@ func (f *FFile) Rename(oldName, newName string, newDir *FFile) error {
 	target := newName
 	log.Println("Renaming source", source, "in", f.Path(), "to", target, " in ", newParent.Path())
 
+	// Only one rename runs at a time so locking both directories cannot deadlock
+	f.fs.renameMu.Lock()
+	defer f.fs.renameMu.Unlock()
+
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
+	if newParent != f.node {
+		newParent.data.Lock()
+		defer newParent.data.Unlock()
+	}
+
 	if err := f.node.Rename(source, target, newParent); err != nil {
 
 		log.Println(err)
 		return errors.Wrapf(err, "could not rename file (%v) from (%v) to (%v)", source, f.Path(), newParent.Path())
 	}
 
-	oldn := f.fs.realify(filepath.Join(f.Path(), source))
-	newn := f.fs.realify(filepath.Join(newParent.Path(), target))
+	oldn := filepath.Join(f.Path(), source)
+	newn := filepath.Join(newParent.Path(), target)
 	log.Println("source:", oldn)
 	log.Println("target:", newn)
 
-	if err := rename(oldn, newn); err != nil {
+	if err := f.fs.backend.Rename(oldn, newn); err != nil {
 		log.Println(err)
-		return errors.Wrapf(err, "could not rename file on disk (%v) from (%v) to %v", source, target, f.node.name)
+		return errors.Wrapf(err, "could not rename file on disk (%v) from (%v) to %v", source, target, f.Name())
 	}
 
 	return nil
@@ -162,9 +218,15 @@ func (f *FFile) Rename(oldName, newName string, newDir *FFile) error {
 
 // Mkdir creats a directory
 func (f *FFile) Mkdir(name string, mode os.FileMode) (*FFile, error) {
-	log.Println("Mkdiring", name, "in", f.node.name)
+	log.Println("Mkdiring", name, "in", f.Name())
 
-	if err := mkdir(f.fs.realify(filepath.Join(f.Path(), name)), mode); err != nil {
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
+	if err := f.fs.backend.Mkdir(filepath.Join(f.Path(), name), mode); err != nil {
 		return nil, errors.Errorf("could not create real dir %v", name)
 	}
 
@@ -177,10 +239,42 @@ func (f *FFile) Mkdir(name string, mode os.FileMode) (*FFile, error) {
 	return child, nil
 }
 
+// Mknod creates a special file (fifo, socket or device) on disk and filetree
+func (f *FFile) Mknod(name string, mode os.FileMode, rdev uint32) (*FFile, error) {
+	log.Println("Mknoding", name, "in", f.Name())
+
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
+	mknoder, ok := f.fs.backend.(BackendMknoder)
+	if !ok {
+		return nil, errors.Wrapf(syscall.EPERM, "could not make node %v as the backend has no special files", name)
+	}
+
+	if err := mknoder.Mknod(filepath.Join(f.Path(), name), mode, rdev); err != nil {
+		return nil, errors.Wrapf(err, "could not make node %v on disk", name)
+	}
+
+	if err := f.node.CreateSpecialChild(name, NodeTypeOf(mode)); err != nil {
+		return nil, errors.Wrapf(err, "could not add node %v to filetree", name)
+	}
+
+	return f.Child(name), nil
+}
+
 func (f *FFile) Link(newName string, old *FFile) (*FFile, error) {
 	oldnode := old.node
 	log.Println("Linking", f.node.Name())
 
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
 	if err := f.node.CreateChild(newName); err != nil {
 		return nil, errors.Wrapf(err, "could not create link to file (%v)", newName)
 	}
@@ -190,7 +284,7 @@ func (f *FFile) Link(newName string, old *FFile) (*FFile, error) {
 		return nil, errors.Errorf("could not find created link in file (%v)", newName)
 	}
 
-	if err := os.Link(f.fs.realify(oldnode.Path()), f.fs.realify(filepath.Join(f.Path(), newName))); err != nil {
+	if err := f.fs.backend.Link(oldnode.Path(), filepath.Join(f.Path(), newName)); err != nil {
 		return nil, errors.Wrapf(err, "could not link file (%v) on disk", newName)
 	}
 
@@ -201,7 +295,13 @@ func (f *FFile) Link(newName string, old *FFile) (*FFile, error) {
 func (f *FFile) Symlink(target, newName string) (*FFile, error) {
 	log.Println("Symlinkig", f.node.Name())
 
-	if err := os.Symlink(target, f.fs.realify(filepath.Join(f.Path(), newName))); err != nil {
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
+	if err := f.fs.backend.Symlink(target, filepath.Join(f.Path(), newName)); err != nil {
 		log.Println("symlink", err)
 		return nil, errors.Wrapf(err, "could not symlink file (%v) with target (%v) on disk", newName, target)
 	}
@@ -224,24 +324,174 @@ func (f *FFile) Readlink() (string, error) {
 	return f.node.Link(), nil
 }
 
-// Setattr to be implemented
-func (f *FFile) Setattr(mode os.FileMode, atime, mtime time.Time) error {
-	log.Println("Setattring", f.node.name)
+// xattrer returns the backend as one keeping extended attributes
+func (f *FFile) xattrer() (BackendXattrer, error) {
+	xattrer, ok := f.fs.backend.(BackendXattrer)
+	if !ok {
+		return nil, errors.Wrapf(syscall.ENOTSUP, "could not use xattrs of file (%v) as the backend has none", f.Name())
+	}
 
-	if err := os.Chmod(f.fs.realify(f.Path()), mode); err != nil {
-		err = errors.Wrapf(err, "could not setattr chmod file")
-		log.Println(err)
+	return xattrer, nil
+}
+
+// Getxattr returns the value of the extended attribute name
+func (f *FFile) Getxattr(name string) ([]byte, error) {
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	// Symlinks have no extended attributes, the errno is what the kernel expects
+	if f.Type() == LINK {
+		return nil, errors.Wrapf(syscall.Errno(fuse.ErrNoXattr), "could not get xattr %v of symlink", name)
+	}
+
+	xattrer, err := f.xattrer()
+	if err != nil {
+		return nil, err
+	}
+
+	f.node.data.RLock()
+	defer f.node.data.RUnlock()
+
+	return xattrer.Getxattr(f.Path(), name)
+}
+
+// Listxattr returns the names of all extended attributes
+func (f *FFile) Listxattr() ([]string, error) {
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	if f.Type() == LINK {
+		return nil, nil
+	}
+
+	xattrer, ok := f.fs.backend.(BackendXattrer)
+	if !ok {
+		return nil, nil
+	}
+
+	f.node.data.RLock()
+	defer f.node.data.RUnlock()
+
+	return xattrer.Listxattr(f.Path())
+}
+
+// Setxattr sets the extended attribute name to value
+func (f *FFile) Setxattr(name string, value []byte, flags uint32) error {
+	log.Println("Setxattring", name, "of", f.Name())
+
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	if f.Type() == LINK {
+		return errors.Wrapf(syscall.EPERM, "could not set xattr %v of symlink", name)
+	}
+
+	xattrer, err := f.xattrer()
+	if err != nil {
 		return err
 	}
 
-	if err := os.Chtimes(f.fs.realify(f.Path()), atime, mtime); err != nil {
-		err = errors.Wrapf(err, "could not setattr chtimes file")
-		log.Println(err)
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
+	return xattrer.Setxattr(f.Path(), name, value, flags)
+}
+
+// Removexattr removes the extended attribute name
+func (f *FFile) Removexattr(name string) error {
+	log.Println("Removexattring", name, "of", f.Name())
+
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	if f.Type() == LINK {
+		return errors.Wrapf(syscall.EPERM, "could not remove xattr %v of symlink", name)
+	}
+
+	xattrer, err := f.xattrer()
+	if err != nil {
 		return err
 	}
 
-	// NOTE: Changing owner not supported yet
-	// TODO: Invistigate previlage escliation to apply chown
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
+	return xattrer.Removexattr(f.Path(), name)
+}
+
+// Setattr applies the attributes marked valid in req to the file
+func (f *FFile) Setattr(req *SetattrRequest) error {
+	log.Println("Setattring", f.Name())
+
+	f.fs.renameMu.RLock()
+	defer f.fs.renameMu.RUnlock()
+
+	f.node.data.Lock()
+	defer f.node.data.Unlock()
+
+	name := f.Path()
+
+	// Refuse ownership changes before anything else is changed
+	chown := req.Valid.Uid() || req.Valid.Gid()
+	chowner, ok := f.fs.backend.(BackendChowner)
+	if chown && (f.fs.chown.mode == chownDeny || !ok) {
+		return errors.Wrapf(syscall.EPERM, "could not setattr chown file")
+	}
+
+	if req.Valid.Mode() {
+		if err := f.fs.backend.Chmod(name, req.Mode); err != nil {
+			err = errors.Wrapf(err, "could not setattr chmod file")
+			log.Println(err)
+			return err
+		}
+	}
+
+	if req.Valid.Size() {
+		if err := f.fs.backend.Truncate(name, int64(req.Size)); err != nil {
+			err = errors.Wrapf(err, "could not setattr truncate file")
+			log.Println(err)
+			return err
+		}
+	}
+
+	if req.Valid.Atime() || req.Valid.Mtime() {
+		attr, err := f.fs.backend.Stat(name)
+		if err != nil {
+			err = errors.Wrapf(err, "could not setattr stat file")
+			log.Println(err)
+			return err
+		}
+
+		// Times that are not being set keep their current value
+		atime, mtime := attr.Atime, attr.Mtime
+		if req.Valid.Atime() {
+			atime = req.Atime
+		}
+		if req.Valid.AtimeNow() {
+			atime = time.Now()
+		}
+		if req.Valid.Mtime() {
+			mtime = req.Mtime
+		}
+		if req.Valid.MtimeNow() {
+			mtime = time.Now()
+		}
+
+		if err := f.fs.backend.Chtimes(name, atime, mtime); err != nil {
+			err = errors.Wrapf(err, "could not setattr chtimes file")
+			log.Println(err)
+			return err
+		}
+	}
+
+	if chown {
+		uid, gid := f.fs.chown.owner(req)
+		if err := chowner.Lchown(name, uid, gid); err != nil {
+			err = errors.Wrapf(err, "could not setattr chown file")
+			log.Println(err)
+			return err
+		}
+	}
 
 	return nil
 }
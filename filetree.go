@@ -2,6 +2,7 @@ package resonatefuse
 
 import (
 	"path/filepath"
+	"sync"
 
 	"bazil.org/fuse"
 	"github.com/pkg/errors"
@@ -11,8 +12,15 @@ import (
 type FileTree struct {
 	name     string
 	link     string
+	kind     NodeType
 	parent   *FileTree
 	children map[string]*FileTree
+
+	// lock guards the structure of the whole tree (names, parents and children)
+	// and is shared by all of its nodes
+	lock *sync.RWMutex
+	// data guards the contents of the node (file data or directory entries on disk)
+	data sync.RWMutex
 }
 
 // NewNode constructs a new file tree node
@@ -24,6 +32,12 @@ func NewNode(name string, parent *FileTree) *FileTree {
 		children: nil,
 	}
 
+	if parent != nil {
+		ft.lock = parent.lock
+	} else {
+		ft.lock = &sync.RWMutex{}
+	}
+
 	return ft
 }
 
@@ -35,6 +49,15 @@ func NewLink(name string, parent *FileTree, linked string) *FileTree {
 	return ft
 }
 
+// NewSpecial constructs a new special file (fifo, socket or device) node
+func NewSpecial(name string, parent *FileTree, kind NodeType) *FileTree {
+
+	ft := NewNode(name, parent)
+	ft.kind = kind
+
+	return ft
+}
+
 // NewDirectory constructs a new file tree with given parent
 func NewDirectory(name string, parent *FileTree) *FileTree {
 
@@ -50,6 +73,9 @@ func (ft *FileTree) Link() string {
 
 // Name gets file's name
 func (ft *FileTree) Name() string {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+
 	return ft.name
 }
 
@@ -59,11 +85,11 @@ func (ft *FileTree) Type() NodeType {
 		return LINK
 	}
 
-	if ft.children == nil {
-		return FILE
+	if ft.children != nil {
+		return DIR
 	}
 
-	return DIR
+	return ft.kind
 }
 
 // CreateChild adds a new child to the filetree
@@ -81,13 +107,25 @@ func (ft *FileTree) CreateLinkChild(name string, link string) error {
 	return ft.AddChild(name, NewLink(filepath.Base(name), ft, link))
 }
 
+// CreateSpecialChild creates a new special file (fifo, socket or device) under the current folder
+func (ft *FileTree) CreateSpecialChild(name string, kind NodeType) error {
+	return ft.AddChild(name, NewSpecial(filepath.Base(name), ft, kind))
+}
+
 // AddChild adds an existing filetree as a child
 func (ft *FileTree) AddChild(name string, child *FileTree) error {
-	if ft.Type() == FILE {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	return ft.addChild(name, child)
+}
+
+func (ft *FileTree) addChild(name string, child *FileTree) error {
+	if ft.Type() != DIR {
 		return errors.New("cannot add child to leaf")
 	}
 
-	current := ft.Child(filepath.Dir(name))
+	current := ft.child(filepath.Dir(name))
 	if current == nil {
 		return errors.Errorf("path %v does not exist", filepath.Dir(name))
 	}
@@ -99,14 +137,34 @@ func (ft *FileTree) AddChild(name string, child *FileTree) error {
 
 	current.children[name] = child
 	child.parent = current
+	child.adopt(ft.lock)
 
 	return nil
 }
 
+// adopt makes a subtree share lock (the subtree may come from another tree)
+func (ft *FileTree) adopt(lock *sync.RWMutex) {
+	if ft.lock == lock {
+		return
+	}
+
+	ft.lock = lock
+	for _, child := range ft.children {
+		child.adopt(lock)
+	}
+}
+
 // RemoveChild removes a child from chosen filetree
 func (ft *FileTree) RemoveChild(name string) error {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	return ft.removeChild(name)
+}
 
-	current := ft.Child(filepath.Dir(name))
+func (ft *FileTree) removeChild(name string) error {
+
+	current := ft.child(filepath.Dir(name))
 	if current == nil {
 		return errors.Errorf("path %v does not exist", filepath.Dir(name))
 	}
@@ -122,18 +180,25 @@ func (ft *FileTree) RemoveChild(name string) error {
 
 // Rename changes the name of the current filetree
 func (ft *FileTree) Rename(oldname string, newName string, newParent *FileTree) error {
-	child := ft.Child(oldname)
+	if ft.lock != newParent.lock {
+		return errors.Errorf("could not rename child (%v) to another tree", oldname)
+	}
+
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	child := ft.child(oldname)
 	if child == nil {
 		return errors.Errorf("could not rename none existant child (%v)", oldname)
 	}
 
-	if err := ft.RemoveChild(oldname); err != nil {
+	if err := ft.removeChild(oldname); err != nil {
 		return errors.Wrapf(err, "could not remove child (%v) while renaming", oldname)
 	}
 
 	child.name = newName
 
-	if err := newParent.AddChild(newName, child); err != nil {
+	if err := newParent.addChild(newName, child); err != nil {
 		return errors.Wrapf(err, "could not add child (%v) while renaming", newName)
 	}
 
@@ -142,6 +207,9 @@ func (ft *FileTree) Rename(oldname string, newName string, newParent *FileTree)
 
 // Children returns all children from chosen filetree
 func (ft *FileTree) Children() []*FileTree {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+
 	childrenList := make([]*FileTree, 0, len(ft.children))
 
 	for _, child := range ft.children {
@@ -153,7 +221,14 @@ func (ft *FileTree) Children() []*FileTree {
 
 // Child returns a specific child from chosen directory
 func (ft *FileTree) Child(name string) *FileTree {
-	if ft.Type() == FILE {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+
+	return ft.child(name)
+}
+
+func (ft *FileTree) child(name string) *FileTree {
+	if ft.Type() != DIR {
 		return nil
 	}
 
@@ -169,11 +244,14 @@ func (ft *FileTree) Child(name string) *FileTree {
 
 // Dirents returns all children from chosen filetree
 func (ft *FileTree) Dirents() []fuse.Dirent {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+
 	childrenList := make([]fuse.Dirent, 0, len(ft.children))
 
 	for _, child := range ft.children {
 		childrenList = append(childrenList, fuse.Dirent{
-			Name: child.Name(),
+			Name: child.name,
 			Type: child.Type().ToFUSE(),
 		})
 	}
@@ -183,11 +261,18 @@ func (ft *FileTree) Dirents() []fuse.Dirent {
 
 // Path returns the path of the filetree with respect to the root parent
 func (ft *FileTree) Path() string {
+	ft.lock.RLock()
+	defer ft.lock.RUnlock()
+
+	return ft.path()
+}
+
+func (ft *FileTree) path() string {
 	if ft.parent == nil {
 		return "."
 	}
 
-	return filepath.Join(ft.parent.Path(), ft.name)
+	return filepath.Join(ft.parent.path(), ft.name)
 }
 
 func (ft *FileTree) String() string {
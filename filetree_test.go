@@ -1,7 +1,9 @@
 package resonatefuse
 
 import (
+	"fmt"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -134,3 +136,37 @@ func TestPath(t *testing.T) {
 	assert.Equal(t, root.Path(), filepath.Join("."))
 	assert.Equal(t, child.Path(), filepath.Join(".", childName))
 }
+
+func TestConcurrentMutations(t *testing.T) {
+	root := NewDirectory("root", nil)
+	assert.Nil(t, root.CreateDirChild("joe"))
+	assert.Nil(t, root.CreateDirChild("leo"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := fmt.Sprintf("ali%v", i)
+			assert.Nil(t, root.Child("joe").CreateChild(name))
+			assert.Nil(t, root.Child("joe").Rename(name, name, root.Child("leo")))
+			assert.Equal(t, filepath.Join("leo", name), root.Child("leo").Child(name).Path())
+			root.Dirents()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, root.Child("joe").Children(), 0)
+	assert.Len(t, root.Child("leo").Children(), 16)
+}
+
+func TestAdoptLock(t *testing.T) {
+	root := NewDirectory("root", nil)
+	other := NewDirectory("joe", nil)
+	assert.Nil(t, other.CreateChild("leo"))
+
+	assert.Nil(t, root.AddChild("joe", other))
+	assert.Equal(t, root.lock, root.Child("joe/leo").lock)
+	assert.NotNil(t, NewDirectory("ali", nil).Rename("joe", "joe", root))
+}
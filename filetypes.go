@@ -1,6 +1,10 @@
 package resonatefuse
 
-import "bazil.org/fuse"
+import (
+	"os"
+
+	"bazil.org/fuse"
+)
 
 type NodeType uint
 
@@ -8,6 +12,10 @@ const (
 	FILE NodeType = iota
 	DIR
 	LINK
+	FIFO
+	SOCKET
+	CHAR
+	BLOCK
 )
 
 func (nt NodeType) ToFUSE() fuse.DirentType {
@@ -18,7 +26,35 @@ func (nt NodeType) ToFUSE() fuse.DirentType {
 		return fuse.DT_Link
 	case DIR:
 		return fuse.DT_Dir
+	case FIFO:
+		return fuse.DT_FIFO
+	case SOCKET:
+		return fuse.DT_Socket
+	case CHAR:
+		return fuse.DT_Char
+	case BLOCK:
+		return fuse.DT_Block
 	default:
 		return fuse.DT_Unknown
 	}
 }
+
+// NodeTypeOf returns the node type of a file with the given mode
+func NodeTypeOf(mode os.FileMode) NodeType {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return LINK
+	case mode.IsDir():
+		return DIR
+	case mode&os.ModeNamedPipe != 0:
+		return FIFO
+	case mode&os.ModeSocket != 0:
+		return SOCKET
+	case mode&os.ModeCharDevice != 0:
+		return CHAR
+	case mode&os.ModeDevice != 0:
+		return BLOCK
+	default:
+		return FILE
+	}
+}
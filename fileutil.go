@@ -1,11 +1,16 @@
 package resonatefuse
 
 import (
-	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"bazil.org/fuse"
 	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
 )
 
 func splitPath(path string) []string {
@@ -20,34 +25,37 @@ func splitPath(path string) []string {
 	return append(splitPath(dir), file)
 }
 
-func writeAt(name string, data []byte, offset int64) (int, error) {
-	file, err := os.OpenFile(name, os.O_RDWR, 0664)
-	if err != nil {
-		return 0, errors.Errorf("could not open file %v: %v", name, err)
+// diskErr converts an error from the origin to the error handed to the kernel,
+// keeping the errno of the failed system call when there is one
+func diskErr(err error, fallback fuse.Errno) error {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return fuse.Errno(errno)
 	}
-	defer file.Close()
 
-	n, err := file.WriteAt(data, offset)
-	if err != nil {
-		return n, errors.Errorf("could not write to file %v: %v", name, err)
-	}
+	return fallback
+}
 
-	return n, nil
+// openFlags are the open flags that are passed on to files in the origin
+const openFlags = os.O_RDONLY | os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_TRUNC | os.O_SYNC
+
+func open(name string, flags int) (*os.File, error) {
+	return os.OpenFile(name, flags&openFlags, 0)
 }
 
-func readAt(name string, data []byte, offset int64) (int, error) {
-	file, err := os.OpenFile(name, os.O_RDONLY, 0664)
+// syncPath commits the file or directory name to stable storage
+func syncPath(name string) error {
+	file, err := os.Open(name)
 	if err != nil {
-		return 0, errors.Errorf("could not open file %v: %v", name, err)
+		return errors.Wrapf(err, "could not open %v to sync", name)
 	}
 	defer file.Close()
 
-	n, err := file.ReadAt(data, offset)
-	if err != nil {
-		return n, errors.Errorf("could not write to file %v: %v", name, err)
+	if err := file.Sync(); err != nil {
+		return errors.Wrapf(err, "could not sync %v", name)
 	}
 
-	return n, nil
+	return nil
 }
 
 func Touch(name string, mode os.FileMode) error {
@@ -58,18 +66,136 @@ func Touch(name string, mode os.FileMode) error {
 	return file.Close()
 }
 
-func rm(name string) error {
-	return os.Remove(name)
+// importPrefix starts the names of the files backends encode plain files into
+// before they replace them, they are hidden from the volume and any left
+// behind by an interrupted import is removed
+const importPrefix = ".resonate-import"
+
+// importing reports whether the file at path is being imported
+func importing(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), importPrefix)
+}
+
+// relink replaces the file name with a hardlink to the file target
+func relink(target, name string) error {
+	tmp := filepath.Join(filepath.Dir(name), importPrefix+"-"+filepath.Base(name))
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not link %v again", name)
+	}
+
+	if err := os.Link(target, tmp); err != nil {
+		return errors.Wrapf(err, "could not link %v again", name)
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "could not link %v again", name)
+	}
+
+	return nil
+}
+
+// replaceFile moves the file tmp over the file name (as described by info),
+// giving it the mode, owner (when allowed), extended attributes and times of
+// the file it replaces first
+func replaceFile(tmp, name string, info os.FileInfo) error {
+	attr, err := fileAttr(info)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Lchown(tmp, int(attr.Uid), int(attr.Gid)); err != nil && !os.IsPermission(err) {
+		return errors.Wrapf(err, "could not replace %v", name)
+	}
+	if err := os.Chmod(tmp, info.Mode()); err != nil {
+		return errors.Wrapf(err, "could not replace %v", name)
+	}
+
+	// Attributes the daemon may not set (or the origin does not keep) are left behind
+	if names, err := listxattr(name); err == nil {
+		for _, xattr := range names {
+			value, err := getxattr(name, xattr)
+			if err == nil {
+				err = setxattr(tmp, xattr, value, 0)
+			}
+			if err != nil {
+				log.Println(errors.Wrapf(err, "could not keep xattr of %v", name))
+			}
+		}
+	}
+
+	if err := os.Chtimes(tmp, attr.Atime, attr.Mtime); err != nil {
+		return errors.Wrapf(err, "could not replace %v", name)
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		return errors.Wrapf(err, "could not replace %v", name)
+	}
+
+	return syncPath(filepath.Dir(name))
 }
 
-func rename(oldn, newn string) error {
-	return os.Rename(oldn, newn)
+// lchtimes changes the access and modification times of the file name,
+// symlinks are changed themselves instead of the files they point to
+func lchtimes(name string, atime, mtime time.Time) error {
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(mtime.UnixNano()),
+	}
+
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, name, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+
+	return nil
 }
 
 func mkdir(name string, mode os.FileMode) error {
 	return os.Mkdir(name, mode)
 }
 
-func readall(path string) ([]byte, error) {
-	return ioutil.ReadFile(path)
+// populate mirrors the contents of the directory dir in backend b into the filetree ft
+func populate(ft *FileTree, b Backend, dir string) error {
+	infos, err := b.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "could not read directory %v", dir)
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+		real := filepath.Join(dir, name)
+
+		switch kind := NodeTypeOf(info.Mode()); kind {
+		case LINK:
+			target, err := b.Readlink(real)
+			if err != nil {
+				return errors.Wrapf(err, "could not read symlink %v", real)
+			}
+
+			if err := ft.CreateLinkChild(name, target); err != nil {
+				return errors.Wrapf(err, "could not add symlink %v to filetree", name)
+			}
+
+		case DIR:
+			if err := ft.CreateDirChild(name); err != nil {
+				return errors.Wrapf(err, "could not add directory %v to filetree", name)
+			}
+
+			if err := populate(ft.Child(name), b, real); err != nil {
+				return err
+			}
+
+		case FILE:
+			if err := ft.CreateChild(name); err != nil {
+				return errors.Wrapf(err, "could not add file %v to filetree", name)
+			}
+
+		default:
+			if err := ft.CreateSpecialChild(name, kind); err != nil {
+				return errors.Wrapf(err, "could not add special file %v to filetree", name)
+			}
+		}
+	}
+
+	return nil
 }
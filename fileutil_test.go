@@ -1,6 +1,9 @@
 package resonatefuse
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,3 +13,22 @@ func TestSplitPath(t *testing.T) {
 	path := "to/joe/man"
 	assert.Equal(t, splitPath(path), []string{"to", "joe", "man"})
 }
+
+func TestPopulate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "joe", "ali"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "joe", "leo"), []byte("leo"), 0644))
+	assert.Nil(t, os.Symlink("joe/leo", filepath.Join(dir, "muhammad")))
+
+	root := NewDirectory("root", nil)
+	assert.Nil(t, populate(root, NewLocalBackend(dir), "."))
+
+	assert.Equal(t, DIR, root.Child("joe").Type())
+	assert.Equal(t, DIR, root.Child("joe/ali").Type())
+	assert.Equal(t, FILE, root.Child("joe/leo").Type())
+	assert.Equal(t, LINK, root.Child("muhammad").Type())
+	assert.Equal(t, "joe/leo", root.Child("muhammad").Link())
+}
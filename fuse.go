@@ -10,6 +10,7 @@ import (
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/pkg/errors"
 )
 
 // FS implements the hello world file system.
@@ -17,8 +18,29 @@ type FS struct {
 	root   *File
 	origin string
 
-	hooks map[HookType]GeneralHook
-	mu    sync.Mutex
+	backend Backend
+	// codecs encode the contents of files in the origin (compression, encryption)
+	codecs []blockCodec
+
+	hooks     map[HookType][]hookEntry
+	postHooks map[HookType][]postHookEntry
+
+	// renameMu serialises renames so they can lock both directories involved,
+	// other operations hold it as readers from resolving the path of a file
+	// until the backend is done with it so the path cannot go stale
+	renameMu sync.RWMutex
+
+	chown       chownPolicy
+	statfsHooks []StatfsHook
+
+	checkPermissions bool
+	mountOptions     []fuse.MountOption
+
+	handlesMu sync.Mutex
+	handles   map[*FileTree]map[*Handle]struct{}
+
+	// processes resolves the process of callers (nil unless requested)
+	processes *processCache
 }
 
 // Root returns the root directory
@@ -30,32 +52,218 @@ func (fs *FS) Origin() string {
 	return fs.origin
 }
 
+// Backend returns where the files of the volume are stored
+func (fs *FS) Backend() Backend {
+	return fs.backend
+}
+
 func (fs *FS) Location() string {
 	return fmt.Sprintf("%v-resonate", fs.origin)
 }
 
-func NewFS(name string, opts ...Option) *FS {
-	// Minimum number of options:
-	// Avoid this check by doing nil checks when calling hooks
-	if len(opts) != 8 {
-		log.Fatalf("could not create filesystem with %v options", len(opts))
-	}
-
+// NewFS creates a filesystem over the origin directory name, hooks are
+// registered through opts and every operation may have any number of them
+func NewFS(name string, opts ...Option) (*FS, error) {
 	fs := &FS{origin: name}
-	fs.root = NewFile(NewFFile(NewDirectory(fs.Location(), nil), fs))
-	fs.hooks = make(map[HookType]GeneralHook)
+	fs.hooks = make(map[HookType][]hookEntry)
+	fs.postHooks = make(map[HookType][]postHookEntry)
+	fs.handles = make(map[*FileTree]map[*Handle]struct{})
 
 	for _, opt := range opts {
-		opt(fs)
+		if err := opt(fs); err != nil {
+			return nil, errors.Wrapf(err, "could not apply option to filesystem (%v)", fs.origin)
+		}
+	}
+
+	if len(fs.codecs) > 0 {
+		if fs.backend != nil {
+			return nil, errors.Errorf("could not encode contents of filesystem (%v) with another backend", fs.origin)
+		}
+
+		backend, err := newBlockBackend(fs.origin, blockSize, fs.codecs...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not create filesystem from origin (%v)", fs.origin)
+		}
+		fs.backend = backend
+	}
+
+	if fs.backend == nil {
+		fs.backend = NewLocalBackend(fs.origin)
+	}
+
+	// Mirror whatever already lives in the backend so it is visible through the mount
+	tree := NewDirectory(fs.Location(), nil)
+	if err := populate(tree, fs.backend, "."); err != nil {
+		return nil, errors.Wrapf(err, "could not create filesystem from origin (%v)", fs.origin)
+	}
+
+	fs.root = NewFile(NewFFile(tree, fs))
+
+	// Only a filesystem that could be created runs asynchronous hooks
+	for _, q := range fs.queues() {
+		q.start()
+	}
+
+	return fs, nil
+}
+
+// hook runs the hooks of an operation in the order they were registered
+// before it is applied, stopping at the first one that fails
+func (fs *FS) hook(operation HookType, req *GeneralRequest) error {
+	for _, entry := range fs.hooks[operation] {
+		if !entry.scope.MatchRequest(req) {
+			continue
+		}
+
+		if entry.async != nil {
+			h, cp := entry.hook, req.clone()
+			err := entry.async.push(func() {
+				if err := h(cp); err != nil {
+					log.Println("asynchronous hook failed:", err)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := entry.hook(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// postHook reports the outcome of an operation to its post hooks in the order they were registered
+func (fs *FS) postHook(operation HookType, req *GeneralRequest, res *GeneralResult) {
+	for _, entry := range fs.postHooks[operation] {
+		if !entry.scope.MatchRequest(req) {
+			continue
+		}
+
+		if entry.async != nil {
+			h, cp, rcp := entry.hook, req.clone(), *res
+			err := entry.async.push(func() {
+				h(cp, &rcp)
+			})
+			if err != nil {
+				log.Println("could not queue asynchronous post hook:", err)
+			}
+			continue
+		}
+
+		entry.hook(req, res)
+	}
+}
+
+// queues returns the queues of the asynchronous hooks
+func (fs *FS) queues() []*asyncQueue {
+	queues := make([]*asyncQueue, 0)
+	for _, entries := range fs.hooks {
+		for _, entry := range entries {
+			if entry.async != nil {
+				queues = append(queues, entry.async)
+			}
+		}
+	}
+
+	for _, entries := range fs.postHooks {
+		for _, entry := range entries {
+			if entry.async != nil {
+				queues = append(queues, entry.async)
+			}
+		}
+	}
+
+	return queues
+}
+
+// Drain waits for all asynchronous hooks to handle their queued requests
+func (fs *FS) Drain() {
+	for _, q := range fs.queues() {
+		q.drain()
+	}
+}
+
+// Close stops the workers of asynchronous hooks once they handled their
+// queued requests, operations that would queue more requests fail afterwards
+func (fs *FS) Close() {
+	for _, q := range fs.queues() {
+		q.close()
+	}
+}
+
+// Statfs reports the capacity of the origin as changed by the statfs hooks
+func (fs *FS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
+	sr := &StatfsRequest{}
+	if statfser, ok := fs.backend.(BackendStatfser); ok {
+		var err error
+		if sr, err = statfser.Statfs(); err != nil {
+			log.Println(err)
+			return diskErr(err, fuse.EIO)
+		}
+	}
+	sr.RequestHeader = fs.requestHeader(ctx, req.Header)
+
+	for _, h := range fs.statfsHooks {
+		if err := h(sr); err != nil {
+			return hookErr(err)
+		}
+	}
+
+	resp.Blocks = sr.Blocks
+	resp.Bfree = sr.Bfree
+	resp.Bavail = sr.Bavail
+	resp.Files = sr.Files
+	resp.Ffree = sr.Ffree
+	resp.Bsize = sr.Bsize
+	resp.Namelen = sr.Namelen
+	resp.Frsize = sr.Frsize
+
+	return nil
+}
+
+// track records an open handle so node operations can reach it
+func (fs *FS) track(h *Handle) {
+	fs.handlesMu.Lock()
+	defer fs.handlesMu.Unlock()
+
+	node := h.node.FFNode.node
+	if fs.handles[node] == nil {
+		fs.handles[node] = make(map[*Handle]struct{})
 	}
+	fs.handles[node][h] = struct{}{}
+}
 
-	return fs
+func (fs *FS) untrack(h *Handle) {
+	fs.handlesMu.Lock()
+	defer fs.handlesMu.Unlock()
+
+	node := h.node.FFNode.node
+	delete(fs.handles[node], h)
+	if len(fs.handles[node]) == 0 {
+		delete(fs.handles, node)
+	}
 }
 
-func (fs *FS) realify(path string) string {
-	return filepath.Join(fs.origin, path)
+// openHandles returns the handles currently open on node
+func (fs *FS) openHandles(node *FileTree) []*Handle {
+	fs.handlesMu.Lock()
+	defer fs.handlesMu.Unlock()
+
+	handles := make([]*Handle, 0, len(fs.handles[node]))
+	for h := range fs.handles[node] {
+		handles = append(handles, h)
+	}
+
+	return handles
 }
 
+var _ fs.FS = (*FS)(nil)
+var _ fs.FSStatfser = (*FS)(nil)
+
 // File is the building node of a filesystem
 type File struct {
 	FFNode *FFile
@@ -78,13 +286,35 @@ func (f *File) Child(name string) *File {
 	return NewFile(child)
 }
 
+// Attr returns some attributes about the file
+func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
+	log.Println("Attring", f.FFNode.Name())
+
+	attr, err := f.FFNode.Attr()
+	if err != nil {
+		log.Println(err)
+		return diskErr(err, fuse.ENOENT)
+	}
+	*a = attr
+
+	return nil
+}
+
 // Lookup returns info about child
-func (f *File) Lookup(ctx context.Context, name string) (fs.Node, error) {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
+func (f *File) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	log.Println("Looking for", req.Name, "in", f.FFNode.Name())
+
+	if err := f.check(ctx, req.Header, permExec); err != nil {
+		return nil, err
+	}
+
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Name: req.Name}
+	if err := f.FFNode.fs.hook(LookupType, gr); err != nil {
+		return nil, hookErr(err)
+	}
 
-	log.Println("Looking for", name, "in", f.FFNode.Name())
-	child, err := f.FFNode.Lookup(name)
+	child, err := f.FFNode.Lookup(gr.Name)
+	f.FFNode.fs.postHook(LookupType, gr, &GeneralResult{Err: err, Path: filepath.Join(gr.Path, gr.Name)})
 	if err != nil {
 		log.Println("lookup faild")
 		return nil, fuse.ENOENT
@@ -95,112 +325,96 @@ func (f *File) Lookup(ctx context.Context, name string) (fs.Node, error) {
 
 // Create creats a new file on disk and filetree
 func (f *File) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
 	log.Println("Creating", req.Name, "in", f.FFNode.Name())
+
+	if err := f.check(ctx, req.Header, permWrite|permExec); err != nil {
+		return nil, nil, err
+	}
 	// First create the file and then add it to the tree (order is important)
 	// err := f.FFNode.fs.createHook(&CreateRequest{f.FFNode.Path(), req.Name, req.Mode})
 
-	err := f.FFNode.fs.hooks[CreateType](&GeneralRequest{Path: f.FFNode.Path(), Name: req.Name, Mode: req.Mode})
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Name: req.Name, Mode: req.Mode}
+	err := f.FFNode.fs.hook(CreateType, gr)
 	if err != nil {
-		return nil, nil, fuse.EIO
+		return nil, nil, hookErr(err)
 	}
 
-	child, err := f.FFNode.Create(req.Name, req.Mode)
+	// The handle uses the file as it was opened when created, opening it
+	// again could be refused by the mode it was created with
+	child, file, err := f.FFNode.Create(gr.Name, gr.Mode, int(req.Flags))
+	f.FFNode.fs.postHook(CreateType, gr, &GeneralResult{Err: err, Path: filepath.Join(gr.Path, gr.Name)})
 	if err != nil {
-		return nil, nil, fuse.EIO
+		log.Println(err)
+		return nil, nil, diskErr(err, fuse.EIO)
 	}
 
-	return NewFile(child), NewFile(child), nil
+	node := NewFile(child)
+	h := newHandle(node, file, req.Flags)
+
+	resp.Flags |= fuse.OpenDirectIO
+
+	return node, h, nil
 }
 
 // Remove removes file from disk and filetree
 func (f *File) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
 	log.Println("Removing", req.Name, "in", f.FFNode.Name())
-	// First remove the file from the tree then remove it from disk (order is important)
 
-	err := f.FFNode.fs.hooks[RemoveType](&GeneralRequest{Path: f.FFNode.Path(), Name: req.Name})
-	if err != nil {
-		return fuse.EIO
+	if err := f.check(ctx, req.Header, permWrite|permExec); err != nil {
+		return err
 	}
+	// First remove the file from the tree then remove it from disk (order is important)
 
-	if err := f.FFNode.Remove(req.Name); err != nil {
-		return syscall.ENOTEMPTY
-	}
-
-	return nil
-}
-
-func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
-	log.Println("Writing", f.FFNode.Name())
-
-	err := f.FFNode.fs.hooks[WriteType](&GeneralRequest{Path: f.FFNode.Path(), Data: req.Data, Offset: req.Offset})
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Name: req.Name}
+	err := f.FFNode.fs.hook(RemoveType, gr)
 	if err != nil {
-		return fuse.EIO
+		return hookErr(err)
 	}
 
-	n, err := f.FFNode.Write(req.Data, req.Offset)
-
+	err = f.FFNode.Remove(gr.Name)
+	f.FFNode.fs.postHook(RemoveType, gr, &GeneralResult{Err: err, Path: filepath.Join(gr.Path, gr.Name)})
 	if err != nil {
-		resp.Size = n
-		log.Println(err)
-		return fuse.EIO
+		return syscall.ENOTEMPTY
 	}
-	resp.Size = n
 
 	return nil
 }
 
-// ReadDirAll returns all children
-func (f *File) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
+// readDirAll returns all children for the caller reading the directory
+func (f *File) readDirAll(ctx context.Context, header fuse.Header) ([]fuse.Dirent, error) {
 	log.Println("ReadDirAlling", f.FFNode.Name())
-	return f.FFNode.ReadDirAll()
-}
-
-// ReadAll returns all bytes in file
-func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
-	log.Println("ReadAlling", f.FFNode.Name())
-	return f.FFNode.ReadAll()
-}
-func (f *File) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
 
-	log.Println("Reading", f.FFNode.Name())
-	if err := f.FFNode.Read(resp.Data, req.Offset); err != nil {
-		log.Println(err)
-		return fuse.EIO
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, header), Path: f.FFNode.Path()}
+	if err := f.FFNode.fs.hook(ReadDirAllType, gr); err != nil {
+		return nil, hookErr(err)
 	}
 
-	return nil
+	dirents, err := f.FFNode.ReadDirAll()
+	f.FFNode.fs.postHook(ReadDirAllType, gr, &GeneralResult{Err: err, Path: gr.Path})
+
+	return dirents, err
 }
 
 // Rename moves a file from source to target
 func (f *File) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
 	log.Println("Renaming source", req.OldName, "in", f.FFNode.Path(), "to", req.NewName)
 
-	err := f.FFNode.fs.hooks[RenameType](&GeneralRequest{Path: f.FFNode.Path(), OldName: req.OldName, NewName: req.NewName, NewDir: newDir.(*File).FFNode.Path()})
+	if err := f.check(ctx, req.Header, permWrite|permExec); err != nil {
+		return err
+	}
+
+	if err := newDir.(*File).check(ctx, req.Header, permWrite|permExec); err != nil {
+		return err
+	}
+
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), OldName: req.OldName, NewName: req.NewName, NewDir: newDir.(*File).FFNode.Path()}
+	err := f.FFNode.fs.hook(RenameType, gr)
 	if err != nil {
-		return fuse.EIO
+		return hookErr(err)
 	}
 
-	err = f.FFNode.Rename(req.OldName, req.NewName, newDir.(*File).FFNode)
+	err = f.FFNode.Rename(gr.OldName, gr.NewName, newDir.(*File).FFNode)
+	f.FFNode.fs.postHook(RenameType, gr, &GeneralResult{Err: err, Path: filepath.Join(gr.NewDir, gr.NewName)})
 	if err != nil {
 		return fuse.EIO
 	}
@@ -210,17 +424,20 @@ func (f *File) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.No
 
 // Mkdir creats a directory
 func (f *File) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
 	log.Println("Mkdiring", req.Name, "in", f.FFNode.Name())
 
-	err := f.FFNode.fs.hooks[MkdirType](&GeneralRequest{Path: f.FFNode.Path(), Name: req.Name, Mode: req.Mode})
+	if err := f.check(ctx, req.Header, permWrite|permExec); err != nil {
+		return nil, err
+	}
+
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Name: req.Name, Mode: req.Mode}
+	err := f.FFNode.fs.hook(MkdirType, gr)
 	if err != nil {
-		return nil, fuse.EIO
+		return nil, hookErr(err)
 	}
 
-	dir, err := f.FFNode.Mkdir(req.Name, req.Mode)
+	dir, err := f.FFNode.Mkdir(gr.Name, gr.Mode)
+	f.FFNode.fs.postHook(MkdirType, gr, &GeneralResult{Err: err, Path: filepath.Join(gr.Path, gr.Name)})
 	if err != nil {
 		return nil, fuse.EIO
 	}
@@ -228,19 +445,45 @@ func (f *File) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, erro
 	return NewFile(dir), nil
 }
 
-func (f *File) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node) (fs.Node, error) {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
+// Mknod creates a fifo, socket or device node
+func (f *File) Mknod(ctx context.Context, req *fuse.MknodRequest) (fs.Node, error) {
+	log.Println("Mknoding", req.Name, "in", f.FFNode.Name())
 
+	if err := f.check(ctx, req.Header, permWrite|permExec); err != nil {
+		return nil, err
+	}
+
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Name: req.Name, Mode: req.Mode, Rdev: req.Rdev}
+	if err := f.FFNode.fs.hook(MknodType, gr); err != nil {
+		return nil, hookErr(err)
+	}
+
+	node, err := f.FFNode.Mknod(gr.Name, gr.Mode, gr.Rdev)
+	f.FFNode.fs.postHook(MknodType, gr, &GeneralResult{Err: err, Path: filepath.Join(gr.Path, gr.Name)})
+	if err != nil {
+		log.Println(err)
+		return nil, diskErr(err, fuse.EIO)
+	}
+
+	return NewFile(node), nil
+}
+
+func (f *File) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node) (fs.Node, error) {
 	oldnode := old.(*File)
 	log.Println("Linking", f.FFNode.Name())
 
-	err := f.FFNode.fs.hooks[LinkType](&GeneralRequest{Path: f.FFNode.Path(), NewName: req.NewName, Old: oldnode.FFNode.Path()})
+	if err := f.check(ctx, req.Header, permWrite|permExec); err != nil {
+		return nil, err
+	}
+
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), NewName: req.NewName, Old: oldnode.FFNode.Path()}
+	err := f.FFNode.fs.hook(LinkType, gr)
 	if err != nil {
-		return nil, fuse.EIO
+		return nil, hookErr(err)
 	}
 
-	link, err := f.FFNode.Link(req.NewName, oldnode.FFNode)
+	link, err := f.FFNode.Link(gr.NewName, oldnode.FFNode)
+	f.FFNode.fs.postHook(LinkType, gr, &GeneralResult{Err: err, Path: filepath.Join(gr.Path, gr.NewName)})
 	if err != nil {
 		return nil, fuse.EIO
 	}
@@ -249,17 +492,20 @@ func (f *File) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node) (fs
 
 }
 func (f *File) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
 	log.Println("Symlinkig", f.FFNode.Name())
 
-	err := f.FFNode.fs.hooks[SymlinkType](&GeneralRequest{Path: f.FFNode.Path(), Target: req.Target, NewName: req.NewName})
+	if err := f.check(ctx, req.Header, permWrite|permExec); err != nil {
+		return nil, err
+	}
+
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Target: req.Target, NewName: req.NewName}
+	err := f.FFNode.fs.hook(SymlinkType, gr)
 	if err != nil {
-		return nil, fuse.EIO
+		return nil, hookErr(err)
 	}
 
-	link, err := f.FFNode.Symlink(req.Target, req.NewName)
+	link, err := f.FFNode.Symlink(gr.Target, gr.NewName)
+	f.FFNode.fs.postHook(SymlinkType, gr, &GeneralResult{Err: err, Path: filepath.Join(gr.Path, gr.NewName)})
 	if err != nil {
 		return nil, fuse.EIO
 	}
@@ -267,82 +513,192 @@ func (f *File) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node,
 	return NewFile(link), nil
 }
 
-// Setattr to be implemented
+// Setattr changes the attributes marked valid by the request
 func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-
 	log.Println("Setattring", f.FFNode.Name())
-	if !req.Valid.Mode() {
-		return nil
+
+	if err := f.checkSetattr(ctx, req); err != nil {
+		return err
 	}
 
-	err := f.FFNode.fs.hooks[SetattrType](&GeneralRequest{Path: f.FFNode.Path(), Mode: req.Mode, Atime: req.Atime, Mtime: req.Mtime})
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Valid: req.Valid, Mode: req.Mode, Size: int64(req.Size), Atime: req.Atime, Mtime: req.Mtime, Uid: req.Uid, Gid: req.Gid}
+	err := f.FFNode.fs.hook(SetattrType, gr)
 	if err != nil {
-		return fuse.EIO
+		return hookErr(err)
+	}
+
+	err = f.FFNode.Setattr(&SetattrRequest{Path: gr.Path, Valid: gr.Valid, Mode: gr.Mode, Size: uint64(gr.Size), Atime: gr.Atime, Mtime: gr.Mtime, Uid: gr.Uid, Gid: gr.Gid})
+	f.FFNode.fs.postHook(SetattrType, gr, &GeneralResult{Err: err, Path: gr.Path})
+	if err != nil {
+		return diskErr(err, fuse.EPERM)
+	}
+
+	return nil
+}
+
+// Getxattr returns an extended attribute of the origin file
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if err := f.check(ctx, req.Header, permRead); err != nil {
+		return err
+	}
+
+	value, err := f.FFNode.Getxattr(req.Name)
+	if err != nil {
+		return diskErr(err, fuse.EIO)
 	}
 
-	if err := f.FFNode.Setattr(req.Mode, req.Atime, req.Mtime); err != nil {
-		return fuse.EPERM
+	resp.Xattr = value
+	return nil
+}
+
+// Listxattr lists the extended attributes of the origin file
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	if err := f.check(ctx, req.Header, permRead); err != nil {
+		return err
+	}
+
+	names, err := f.FFNode.Listxattr()
+	if err != nil {
+		return diskErr(err, fuse.EIO)
+	}
+
+	resp.Append(names...)
+	return nil
+}
+
+// Setxattr sets an extended attribute of the origin file
+func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	if err := f.check(ctx, req.Header, permWrite); err != nil {
+		return err
+	}
+
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Xattr: req.Name, Data: req.Xattr, XattrFlags: req.Flags}
+	if err := f.FFNode.fs.hook(SetxattrType, gr); err != nil {
+		return hookErr(err)
+	}
+
+	err := f.FFNode.Setxattr(gr.Xattr, gr.Data, gr.XattrFlags)
+	f.FFNode.fs.postHook(SetxattrType, gr, &GeneralResult{Err: err, Path: gr.Path})
+	if err != nil {
+		log.Println(err)
+		return diskErr(err, fuse.EIO)
+	}
+
+	return nil
+}
+
+// Removexattr removes an extended attribute of the origin file
+func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	if err := f.check(ctx, req.Header, permWrite); err != nil {
+		return err
+	}
+
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Xattr: req.Name}
+	if err := f.FFNode.fs.hook(RemovexattrType, gr); err != nil {
+		return hookErr(err)
+	}
+
+	err := f.FFNode.Removexattr(gr.Xattr)
+	f.FFNode.fs.postHook(RemovexattrType, gr, &GeneralResult{Err: err, Path: gr.Path})
+	if err != nil {
+		log.Println(err)
+		return diskErr(err, fuse.EIO)
 	}
 
 	return nil
 }
 
 func (f *File) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-	return f.FFNode.Readlink()
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path()}
+	if err := f.FFNode.fs.hook(ReadlinkType, gr); err != nil {
+		return "", hookErr(err)
+	}
+
+	target, err := f.FFNode.Readlink()
+	f.FFNode.fs.postHook(ReadlinkType, gr, &GeneralResult{Err: err, Path: gr.Path})
+
+	return target, err
 }
 
 func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
 	log.Println("Opening", f.FFNode.Name())
+
+	if err := f.check(ctx, req.Header, openMask(req.Flags)); err != nil {
+		return nil, err
+	}
+
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path(), Flags: req.Flags}
+	if err := f.FFNode.fs.hook(OpenType, gr); err != nil {
+		return nil, hookErr(err)
+	}
+
+	if f.FFNode.Type() == DIR {
+		f.FFNode.fs.postHook(OpenType, gr, &GeneralResult{Path: gr.Path})
+		return &DirHandle{node: f}, nil
+	}
+
+	h, err := NewHandle(f, gr.Flags)
+	f.FFNode.fs.postHook(OpenType, gr, &GeneralResult{Err: err, Path: gr.Path})
+	if err != nil {
+		log.Println(err)
+		return nil, fuse.EIO
+	}
+
 	resp.Flags |= fuse.OpenDirectIO
-	return f, nil
+
+	return h, nil
 }
 
-// Fsync to be implemented
+// Fsync commits the file to stable storage through its open handles (or the
+// file itself when none is open)
 func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
 	log.Println("Fsyncing", f.FFNode.Name())
-	return nil
-}
 
-// Flush to be implemented
-func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-	log.Println("Flushing", f.FFNode.Name())
+	gr := &GeneralRequest{RequestHeader: f.FFNode.fs.requestHeader(ctx, req.Header), Path: f.FFNode.Path()}
+	if err := f.FFNode.fs.hook(FsyncType, gr); err != nil {
+		return hookErr(err)
+	}
+
+	err := f.sync()
+	f.FFNode.fs.postHook(FsyncType, gr, &GeneralResult{Err: err, Path: gr.Path})
+	if err != nil {
+		log.Println(err)
+		return diskErr(err, fuse.EIO)
+	}
+
 	return nil
 }
 
-// Release to be implemented
-func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
-	f.FFNode.fs.mu.Lock()
-	defer f.FFNode.fs.mu.Unlock()
-	log.Println("Releasing", f.FFNode.Name())
+func (f *File) sync() error {
+	handles := f.FFNode.fs.openHandles(f.FFNode.node)
+	if f.FFNode.Type() == DIR || len(handles) == 0 {
+		return f.FFNode.Sync()
+	}
+
+	for _, h := range handles {
+		if err := h.Sync(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-var _ fs.HandleFlusher = (*File)(nil)
-var _ fs.HandleReadDirAller = (*File)(nil)
-var _ fs.HandleReader = (*File)(nil)
-var _ fs.HandleReadAller = (*File)(nil)
-var _ fs.HandleReleaser = (*File)(nil)
-var _ fs.HandleWriter = (*File)(nil)
-
 var _ fs.Node = (*File)(nil)
+var _ fs.NodeAccesser = (*File)(nil)
 var _ fs.NodeCreater = (*File)(nil)
 var _ fs.NodeFsyncer = (*File)(nil)
+var _ fs.NodeGetxattrer = (*File)(nil)
 var _ fs.NodeLinker = (*File)(nil)
+var _ fs.NodeListxattrer = (*File)(nil)
 var _ fs.NodeMkdirer = (*File)(nil)
+var _ fs.NodeMknoder = (*File)(nil)
 var _ fs.NodeOpener = (*File)(nil)
 var _ fs.NodeReadlinker = (*File)(nil)
 var _ fs.NodeRemover = (*File)(nil)
+var _ fs.NodeRemovexattrer = (*File)(nil)
 var _ fs.NodeRenamer = (*File)(nil)
 var _ fs.NodeSetattrer = (*File)(nil)
-var _ fs.NodeStringLookuper = (*File)(nil)
+var _ fs.NodeSetxattrer = (*File)(nil)
+var _ fs.NodeRequestLookuper = (*File)(nil)
 var _ fs.NodeSymlinker = (*File)(nil)
@@ -1,7 +1,18 @@
 package resonatefuse
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestFuse(t *testing.T) {
@@ -18,3 +29,630 @@ func TestFuse(t *testing.T) {
 	// t.Log(root.node.Dirents())
 	// t.Fail()
 }
+
+// newTestFS creates a filesystem over a temporary origin
+func newTestFS(t *testing.T, opts ...Option) (*FS, func()) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+
+	rfs, err := NewFS(origin, opts...)
+	assert.Nil(t, err)
+
+	return rfs, func() {
+		if rfs != nil {
+			rfs.Close()
+		}
+		os.RemoveAll(origin)
+	}
+}
+
+// realify returns where path is stored in the origin of a volume over the local backend
+func (fs *FS) realify(path string) string {
+	return filepath.Join(fs.origin, path)
+}
+
+func TestPostHook(t *testing.T) {
+	var results []*GeneralResult
+	post := func(req *GeneralRequest, res *GeneralResult) {
+		results = append(results, res)
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralPostOption(CreateType, post), GeneralPostOption(WriteType, post))
+	defer cleanup()
+
+	ctx := context.Background()
+	node, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("leo")}, &fuse.WriteResponse{}))
+
+	// Creating a file inside a file fails and must be reported as such
+	_, _, err = node.(*File).Create(ctx, &fuse.CreateRequest{Name: "ali", Mode: 0644}, &fuse.CreateResponse{})
+	assert.NotNil(t, err)
+
+	assert.Len(t, results, 3)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, "joe", results[0].Path)
+	assert.Nil(t, results[1].Err)
+	assert.Equal(t, 3, results[1].Written)
+	assert.NotNil(t, results[2].Err)
+}
+
+func TestReadHook(t *testing.T) {
+	var lookups []string
+	lookup := func(req *GeneralRequest) error {
+		lookups = append(lookups, req.Name)
+		return nil
+	}
+	deny := func(req *GeneralRequest) error {
+		if req.Path == "secret" {
+			return os.ErrPermission
+		}
+		return nil
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralOption(LookupType, lookup), GeneralOption(ReadType, deny))
+	defer cleanup()
+
+	ctx := context.Background()
+	for _, name := range []string{"secret", "public"} {
+		_, _, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: name, Mode: 0644}, &fuse.CreateResponse{})
+		assert.Nil(t, err)
+	}
+
+	secret, err := rfs.root.Lookup(ctx, &fuse.LookupRequest{Name: "secret"}, &fuse.LookupResponse{})
+	assert.Nil(t, err)
+	public, err := rfs.root.Lookup(ctx, &fuse.LookupRequest{Name: "public"}, &fuse.LookupResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"secret", "public"}, lookups)
+
+	for node, denied := range map[fs.Node]bool{secret: true, public: false} {
+		h, err := node.(*File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+
+		err = h.(*Handle).Read(ctx, &fuse.ReadRequest{Size: 16}, &fuse.ReadResponse{Data: make([]byte, 0, 16)})
+		assert.Equal(t, denied, err != nil)
+	}
+}
+
+func TestRewriteHook(t *testing.T) {
+	redirect := func(req *GeneralRequest) error {
+		req.Name = "leo"
+		return nil
+	}
+	stamp := func(req *GeneralRequest) error {
+		req.Data = append([]byte("stamped:"), req.Data...)
+		return nil
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralOption(CreateType, redirect), GeneralOption(WriteType, stamp))
+	defer cleanup()
+
+	ctx := context.Background()
+	node, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644, Flags: fuse.OpenWriteOnly}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, "leo", node.(*File).FFNode.Name())
+	assert.Nil(t, rfs.root.Child("joe"))
+
+	resp := &fuse.WriteResponse{}
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("data")}, resp))
+	assert.Equal(t, 4, resp.Size)
+
+	data, err := ioutil.ReadFile(rfs.realify("leo"))
+	assert.Nil(t, err)
+	assert.Equal(t, "stamped:data", string(data))
+}
+
+func TestHookError(t *testing.T) {
+	deny := func(req *GeneralRequest) error {
+		return errors.Wrap(NewHookError(syscall.EACCES, "writes are not allowed"), "policy")
+	}
+	fail := func(req *GeneralRequest) error {
+		return errors.New("disk on fire")
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralOption(WriteType, deny), GeneralOption(MkdirType, fail))
+	defer cleanup()
+
+	ctx := context.Background()
+	_, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644, Flags: fuse.OpenWriteOnly}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+
+	err = h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("leo")}, &fuse.WriteResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EACCES), fuse.ToErrno(err))
+
+	_, err = rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Name: "ali", Mode: os.ModeDir | 0755})
+	assert.Equal(t, fuse.EIO, fuse.ToErrno(err))
+}
+
+func TestHookChain(t *testing.T) {
+	var order []int
+	hook := func(i int, err error) GeneralHook {
+		return func(*GeneralRequest) error {
+			order = append(order, i)
+			return err
+		}
+	}
+
+	rfs, cleanup := newTestFS(t,
+		GeneralOption(MkdirType, hook(1, nil)),
+		GeneralOption(MkdirType, hook(2, errors.New("stop"))),
+		GeneralOption(MkdirType, hook(3, nil)),
+	)
+	defer cleanup()
+
+	ctx := context.Background()
+	_, err := rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Name: "joe", Mode: os.ModeDir | 0755})
+	assert.NotNil(t, err)
+	assert.Equal(t, []int{1, 2}, order)
+
+	// Operations without hooks go straight through
+	_, _, err = rfs.root.Create(ctx, &fuse.CreateRequest{Name: "leo", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+}
+
+func TestNewFSErrors(t *testing.T) {
+	_, err := NewFS("/does/not/exist")
+	assert.NotNil(t, err)
+
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	_, err = NewFS(origin, GeneralOption(CreateType, nil))
+	assert.NotNil(t, err)
+	_, err = NewFS(origin, GeneralOption(HookType(0), func(*GeneralRequest) error { return nil }))
+	assert.NotNil(t, err)
+}
+
+func TestAsyncHook(t *testing.T) {
+	seen := make(chan string, 1)
+	notify := func(req *GeneralRequest) error {
+		seen <- string(req.Data)
+		return errors.New("ignored")
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralOption(WriteType, notify, Async(1, 1, OverflowBlock)))
+	defer cleanup()
+
+	ctx := context.Background()
+	_, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644, Flags: fuse.OpenWriteOnly}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+
+	data := []byte("leo")
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: data}, &fuse.WriteResponse{}))
+	copy(data, "ali")
+
+	rfs.Drain()
+	assert.Equal(t, "leo", <-seen)
+}
+
+func TestHandle(t *testing.T) {
+	rfs, cleanup := newTestFS(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	node, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("leo"), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Nil(t, node.(*File).Fsync(ctx, &fuse.FsyncRequest{}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+	assert.Len(t, rfs.openHandles(node.(*File).FFNode.node), 0)
+
+	// Appending ignores the offset given by the caller
+	h, err = node.(*File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("ali"), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	h, err = node.(*File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, 16)}
+	assert.Nil(t, h.(*Handle).Read(ctx, &fuse.ReadRequest{Size: 16}, resp))
+	assert.Equal(t, "leoali", string(resp.Data))
+
+	// Writing through a read only handle fails
+	assert.NotNil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("joe")}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	// Truncating on open empties the file
+	h, err = node.(*File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenTruncate}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	data, err := ioutil.ReadFile(rfs.realify("joe"))
+	assert.Nil(t, err)
+	assert.Len(t, data, 0)
+}
+
+func TestCreateReadOnly(t *testing.T) {
+	rfs, cleanup := newTestFS(t)
+	defer cleanup()
+
+	// The mode of files is only enforced for users other than root
+	if os.Geteuid() == 0 {
+		assert.Nil(t, os.Chmod(rfs.origin, 0777))
+		uid, gid := os.Geteuid(), os.Getegid()
+		assert.Nil(t, syscall.Setegid(65534))
+		assert.Nil(t, syscall.Seteuid(65534))
+		defer func() {
+			assert.Nil(t, syscall.Seteuid(uid))
+			assert.Nil(t, syscall.Setegid(gid))
+		}()
+	}
+
+	// Like cp and tar a read only file is created and then written
+	ctx := context.Background()
+	_, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0444, Flags: fuse.OpenWriteOnly}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("leo")}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	data, err := ioutil.ReadFile(rfs.realify("joe"))
+	assert.Nil(t, err)
+	assert.Equal(t, "leo", string(data))
+}
+
+func TestReadSize(t *testing.T) {
+	var size int64
+	resize := func(req *GeneralRequest) error {
+		req.Size = size
+		return nil
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralOption(ReadType, resize))
+	defer cleanup()
+
+	ctx := context.Background()
+	_, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("leoali")}, &fuse.WriteResponse{}))
+
+	// Sizes beyond the response are cut to it and negative ones are refused
+	size = 1 << 20
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, 3)}
+	assert.Nil(t, h.(*Handle).Read(ctx, &fuse.ReadRequest{Size: 3}, resp))
+	assert.Equal(t, "leo", string(resp.Data))
+
+	size = -1
+	err = h.(*Handle).Read(ctx, &fuse.ReadRequest{Size: 3}, &fuse.ReadResponse{Data: make([]byte, 0, 3)})
+	assert.Equal(t, fuse.Errno(syscall.EINVAL), fuse.ToErrno(err))
+}
+
+// gatedBackend holds Stat of the file at path until release is closed
+type gatedBackend struct {
+	*LocalBackend
+	path             string
+	entered, release chan struct{}
+}
+
+func (b *gatedBackend) Stat(path string) (fuse.Attr, error) {
+	if path == b.path {
+		close(b.entered)
+		<-b.release
+	}
+
+	return b.LocalBackend.Stat(path)
+}
+
+func TestRenameAncestor(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	gate := &gatedBackend{LocalBackend: NewLocalBackend(origin), path: "joe/leo", entered: make(chan struct{}), release: make(chan struct{})}
+	rfs, err := NewFS(origin, BackendOption(gate))
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	dir, err := rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Name: "joe", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	node, _, err := dir.(*File).Create(ctx, &fuse.CreateRequest{Name: "leo", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+
+	attred := make(chan error)
+	go func() {
+		attred <- node.(*File).Attr(ctx, &fuse.Attr{})
+	}()
+	<-gate.entered
+
+	// Moving the directory waits for the file to be done with its path
+	renamed := make(chan error)
+	go func() {
+		renamed <- rfs.root.Rename(ctx, &fuse.RenameRequest{OldName: "joe", NewName: "ali"}, rfs.root)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(gate.release)
+
+	assert.Nil(t, <-attred)
+	assert.Nil(t, <-renamed)
+	assert.NotNil(t, rfs.root.Child("ali"))
+}
+
+func TestSetattr(t *testing.T) {
+	var valids []fuse.SetattrValid
+	record := func(req *GeneralRequest) error {
+		valids = append(valids, req.Valid)
+		return nil
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralOption(SetattrType, record))
+	defer cleanup()
+
+	ctx := context.Background()
+	node, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644, Flags: fuse.OpenWriteOnly}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("leoali")}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	assert.Nil(t, node.(*File).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 3}, &fuse.SetattrResponse{}))
+
+	// Setting one time leaves the other one alone
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Nil(t, node.(*File).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrMtime, Mtime: mtime}, &fuse.SetattrResponse{}))
+	assert.Nil(t, node.(*File).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrAtime, Atime: mtime}, &fuse.SetattrResponse{}))
+
+	info, err := os.Stat(rfs.realify("joe"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), info.Size())
+	assert.Equal(t, os.FileMode(0644), info.Mode())
+	assert.True(t, mtime.Equal(info.ModTime()))
+
+	assert.Equal(t, []fuse.SetattrValid{fuse.SetattrSize, fuse.SetattrMtime, fuse.SetattrAtime}, valids)
+}
+
+func TestSetattrSymlink(t *testing.T) {
+	outside, err := ioutil.TempFile("", "resonatefuse")
+	assert.Nil(t, err)
+	outside.Close()
+	defer os.Remove(outside.Name())
+	before, err := os.Stat(outside.Name())
+	assert.Nil(t, err)
+
+	// Setting times on a symlink changes the symlink, not what it points to
+	for _, backend := range []Backend{nil, NewMemoryBackend()} {
+		opts := []Option{}
+		if backend != nil {
+			opts = append(opts, BackendOption(backend))
+		}
+		rfs, cleanup := newTestFS(t, opts...)
+		defer cleanup()
+
+		ctx := context.Background()
+		link, err := rfs.root.Symlink(ctx, &fuse.SymlinkRequest{NewName: "joe", Target: outside.Name()})
+		assert.Nil(t, err)
+
+		mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		assert.Nil(t, link.(*File).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrMtime, Mtime: mtime}, &fuse.SetattrResponse{}))
+
+		attr, err := rfs.Backend().Stat("joe")
+		assert.Nil(t, err)
+		assert.True(t, mtime.Equal(attr.Mtime))
+
+		after, err := os.Stat(outside.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, before.ModTime(), after.ModTime())
+	}
+}
+
+func TestChown(t *testing.T) {
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	chown := &fuse.SetattrRequest{Valid: fuse.SetattrUid | fuse.SetattrGid, Uid: uid, Gid: gid}
+
+	for _, tc := range []struct {
+		option Option
+		errno  fuse.Errno
+	}{
+		{option: ChownPassthrough(), errno: 0},
+		{option: ChownTo(uid, gid), errno: 0},
+		{option: ChownDeny(), errno: fuse.Errno(syscall.EPERM)},
+	} {
+		rfs, cleanup := newTestFS(t, tc.option)
+
+		ctx := context.Background()
+		node, _, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644}, &fuse.CreateResponse{})
+		assert.Nil(t, err)
+
+		err = node.(*File).Setattr(ctx, chown, &fuse.SetattrResponse{})
+		if tc.errno == 0 {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, tc.errno, fuse.ToErrno(err))
+		}
+
+		cleanup()
+	}
+
+	// Mapping replaces only the ids that are being changed
+	policy := chownPolicy{mode: chownMap, uid: 7, gid: 8}
+	u, g := policy.owner(&SetattrRequest{Valid: fuse.SetattrGid, Uid: 1, Gid: 2})
+	assert.Equal(t, []int{-1, 8}, []int{u, g})
+}
+
+func TestXattr(t *testing.T) {
+	var names []string
+	record := func(req *GeneralRequest) error {
+		names = append(names, req.Xattr+"="+string(req.Data))
+		return nil
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralOption(SetxattrType, record), GeneralOption(RemovexattrType, record))
+	defer cleanup()
+
+	ctx := context.Background()
+	node, _, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	file := node.(*File)
+
+	// Symlinks have no attributes at all
+	link, err := rfs.root.Symlink(ctx, &fuse.SymlinkRequest{NewName: "ali", Target: "joe"})
+	assert.Nil(t, err)
+	err = link.(*File).Getxattr(ctx, &fuse.GetxattrRequest{Name: "security.selinux"}, &fuse.GetxattrResponse{})
+	assert.Equal(t, fuse.ErrNoXattr, fuse.ToErrno(err))
+
+	err = file.Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.leo", Xattr: []byte("ali")})
+	if fuse.ToErrno(err) == fuse.Errno(syscall.EOPNOTSUPP) {
+		t.Skip("origin does not support extended attributes")
+	}
+	assert.Nil(t, err)
+
+	get := &fuse.GetxattrResponse{}
+	assert.Nil(t, file.Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.leo"}, get))
+	assert.Equal(t, "ali", string(get.Xattr))
+
+	list := &fuse.ListxattrResponse{}
+	assert.Nil(t, file.Listxattr(ctx, &fuse.ListxattrRequest{}, list))
+	assert.Contains(t, string(list.Xattr), "user.leo\x00")
+
+	assert.Nil(t, file.Removexattr(ctx, &fuse.RemovexattrRequest{Name: "user.leo"}))
+	err = file.Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.leo"}, &fuse.GetxattrResponse{})
+	assert.Equal(t, fuse.ErrNoXattr, fuse.ToErrno(err))
+
+	assert.Equal(t, []string{"user.leo=ali", "user.leo="}, names)
+}
+
+func TestMknod(t *testing.T) {
+	var modes []os.FileMode
+	record := func(req *GeneralRequest) error {
+		modes = append(modes, req.Mode)
+		return nil
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralOption(MknodType, record))
+	defer cleanup()
+
+	ctx := context.Background()
+	node, err := rfs.root.Mknod(ctx, &fuse.MknodRequest{Name: "joe", Mode: os.ModeNamedPipe | 0644})
+	assert.Nil(t, err)
+	assert.Equal(t, FIFO, node.(*File).FFNode.Type())
+
+	info, err := os.Lstat(rfs.realify("joe"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeNamedPipe, info.Mode()&os.ModeType)
+
+	dirents, err := rfs.root.FFNode.ReadDirAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{{Name: "joe", Type: fuse.DT_FIFO}}, dirents)
+	assert.Equal(t, []os.FileMode{os.ModeNamedPipe | 0644}, modes)
+
+	// Special files already in the origin show up as well
+	rfs, err = NewFS(rfs.Origin())
+	assert.Nil(t, err)
+	assert.Equal(t, FIFO, rfs.root.FFNode.Child("joe").Type())
+}
+
+func TestStatfs(t *testing.T) {
+	rfs, cleanup := newTestFS(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	resp := &fuse.StatfsResponse{}
+	assert.Nil(t, rfs.Statfs(ctx, &fuse.StatfsRequest{}, resp))
+	assert.NotZero(t, resp.Blocks)
+	assert.NotZero(t, resp.Bsize)
+
+	virtual, err := NewFS(rfs.Origin(), VirtualCapacity(uint64(resp.Frsize)*(resp.Blocks-resp.Bfree+10)))
+	assert.Nil(t, err)
+
+	vresp := &fuse.StatfsResponse{}
+	assert.Nil(t, virtual.Statfs(ctx, &fuse.StatfsRequest{}, vresp))
+	assert.Equal(t, resp.Blocks-resp.Bfree+10, vresp.Blocks)
+	assert.True(t, vresp.Bavail <= vresp.Bfree)
+}
+
+func TestFsync(t *testing.T) {
+	var synced []string
+	record := func(req *GeneralRequest, res *GeneralResult) {
+		assert.Nil(t, res.Err)
+		synced = append(synced, res.Path)
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralPostOption(FsyncType, record), GeneralPostOption(FlushType, record))
+	defer cleanup()
+
+	ctx := context.Background()
+	dir, err := rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Name: "joe", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	node, h, err := dir.(*File).Create(ctx, &fuse.CreateRequest{Name: "leo", Mode: 0644, Flags: fuse.OpenWriteOnly}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("ali")}, &fuse.WriteResponse{}))
+	assert.Nil(t, node.(*File).Fsync(ctx, &fuse.FsyncRequest{}))
+	assert.Nil(t, h.(*Handle).Flush(ctx, &fuse.FlushRequest{}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+
+	// Without open handles the file and directories are synced directly
+	assert.Nil(t, node.(*File).Fsync(ctx, &fuse.FsyncRequest{}))
+	assert.Nil(t, dir.(*File).Fsync(ctx, &fuse.FsyncRequest{Dir: true}))
+
+	assert.Equal(t, []string{"joe/leo", "joe/leo", "joe/leo", "joe"}, synced)
+}
+
+func TestTypedHooks(t *testing.T) {
+	var renames []RenameRequest
+	rename := func(req *RenameRequest) error {
+		req.RequestHeader = RequestHeader{}
+		renames = append(renames, *req)
+		return nil
+	}
+	create := func(req *CreateRequest) error {
+		req.Mode = 0600
+		return nil
+	}
+
+	rfs, cleanup := newTestFS(t,
+		OnCreate(create),
+		OnRename(rename, Matching("*.txt")),
+		GeneralOption(RenameType, func(*GeneralRequest) error { return nil }),
+	)
+	defer cleanup()
+
+	ctx := context.Background()
+	_, _, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+
+	info, err := os.Stat(rfs.realify("joe"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode())
+
+	assert.Nil(t, rfs.root.Rename(ctx, &fuse.RenameRequest{OldName: "joe", NewName: "leo"}, rfs.root))
+	assert.Nil(t, rfs.root.Rename(ctx, &fuse.RenameRequest{OldName: "leo", NewName: "leo.txt"}, rfs.root))
+	assert.Equal(t, []RenameRequest{{Path: ".", OldName: "leo", NewName: "leo.txt", NewDir: "."}}, renames)
+
+	_, err = NewFS(rfs.Origin(), OnWrite(nil))
+	assert.NotNil(t, err)
+}
+
+func TestRequestHeader(t *testing.T) {
+	var callers []Caller
+	hook := func(req *GeneralRequest) error {
+		callers = append(callers, req.Caller)
+		return req.Context().Err()
+	}
+
+	rfs, cleanup := newTestFS(t, GeneralOption(MkdirType, hook), GeneralOption(ReadDirAllType, hook))
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	header := fuse.Header{Uid: 1000, Gid: 100, Pid: 42}
+	_, err := rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Header: header, Name: "joe", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+
+	// Listing a directory is done for the caller reading it
+	h, err := rfs.root.Open(ctx, &fuse.OpenRequest{Header: header, Dir: true}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, 4096)}
+	assert.Nil(t, h.(*DirHandle).Read(ctx, &fuse.ReadRequest{Header: header, Dir: true, Size: 4096}, resp))
+	assert.Contains(t, string(resp.Data), "joe")
+	assert.Nil(t, h.(*DirHandle).Release(ctx, &fuse.ReleaseRequest{Header: header, Dir: true}))
+
+	// An interrupted operation is seen by the hook through its context
+	cancel()
+	_, err = rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Header: header, Name: "leo", Mode: os.ModeDir | 0755})
+	assert.Equal(t, fuse.EIO, err)
+
+	_, err = os.Stat(rfs.realify("leo"))
+	assert.True(t, os.IsNotExist(err))
+	caller := Caller{Uid: 1000, Gid: 100, Pid: 42}
+	assert.Equal(t, []Caller{caller, caller, caller}, callers)
+	assert.NotNil(t, (&GeneralRequest{}).Context())
+}
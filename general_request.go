@@ -1,10 +1,51 @@
 package resonatefuse
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"time"
+
+	"bazil.org/fuse"
 )
 
+// Caller identifies the process that made an operation, Process is only
+// known when the volume resolves callers (see CallerProcesses)
+type Caller struct {
+	Uid uint32
+	Gid uint32
+	Pid uint32
+
+	Process *Process
+}
+
+// RequestHeader is part of every hook request and carries what is known
+// about the operation besides its arguments
+type RequestHeader struct {
+	Caller Caller
+
+	ctx context.Context
+}
+
+func (fs *FS) requestHeader(ctx context.Context, header fuse.Header) RequestHeader {
+	caller := Caller{Uid: header.Uid, Gid: header.Gid, Pid: header.Pid}
+	if fs.processes != nil {
+		caller.Process = fs.processes.lookup(header.Pid)
+	}
+
+	return RequestHeader{Caller: caller, ctx: ctx}
+}
+
+// Context returns the context of the operation, it is cancelled when the
+// kernel interrupts the operation (for example when the caller is killed)
+func (h *RequestHeader) Context() context.Context {
+	if h.ctx == nil {
+		return context.Background()
+	}
+
+	return h.ctx
+}
+
 type HookType uint16
 
 const (
@@ -16,12 +57,38 @@ const (
 	LinkType
 	SymlinkType
 	SetattrType
+	LookupType
+	OpenType
+	ReadType
+	ReadDirAllType
+	ReadlinkType
+	ReleaseType
+	SetxattrType
+	RemovexattrType
+	MknodType
+	FsyncType
+	FlushType
+
+	// hookTypeEnd marks the end of the hook types and must stay last
+	hookTypeEnd
 )
 
+func (t HookType) valid() bool {
+	return t >= CreateType && t < hookTypeEnd
+}
+
+// GeneralHook is called before an operation is applied, returning an error
+// aborts the operation. The hook may change the request and the operation is
+// then applied with the changed values (Path and NewDir only locate the
+// operation and changing them has no effect).
 type GeneralHook func(*GeneralRequest) error
 type GeneralRequest struct {
+	RequestHeader
+
 	Atime   time.Time
 	Data    []byte
+	Flags   fuse.OpenFlags
+	Gid     uint32
 	Mode    os.FileMode
 	Mtime   time.Time
 	Name    string
@@ -31,5 +98,67 @@ type GeneralRequest struct {
 	OldName string
 	Old     string
 	Path    string
+	Rdev    uint32
+	Size    int64
 	Target  string
+	Uid     uint32
+	// Valid marks the attributes a setattr changes (a truncate sets Size, a chmod Mode)
+	Valid fuse.SetattrValid
+	// Xattr is the name of the extended attribute being changed (its value is in Data)
+	Xattr      string
+	XattrFlags uint32
+}
+
+// clone returns a deep copy of the request that outlives the operation
+// (its context is no longer tied to the operation)
+func (req *GeneralRequest) clone() *GeneralRequest {
+	cp := *req
+	cp.ctx = context.Background()
+	if req.Data != nil {
+		cp.Data = append([]byte(nil), req.Data...)
+	}
+
+	return &cp
+}
+
+// paths returns the paths of the nodes an operation touches
+func (req *GeneralRequest) paths() []string {
+	paths := make([]string, 0, 3)
+
+	for _, name := range []string{req.Name, req.OldName} {
+		if name != "" {
+			paths = append(paths, filepath.Join(req.Path, name))
+		}
+	}
+
+	if req.NewName != "" {
+		dir := req.Path
+		if req.NewDir != "" {
+			dir = req.NewDir
+		}
+		paths = append(paths, filepath.Join(dir, req.NewName))
+	}
+
+	if req.Old != "" {
+		paths = append(paths, req.Old)
+	}
+
+	// Operations on the node itself
+	if len(paths) == 0 {
+		paths = append(paths, req.Path)
+	}
+
+	return paths
+}
+
+type GeneralPostHook func(*GeneralRequest, *GeneralResult)
+
+// GeneralResult is the outcome of an operation as seen by post hooks
+type GeneralResult struct {
+	// Err is the error returned by the operation (nil if it was committed)
+	Err error
+	// Written is the number of bytes written by a write operation
+	Written int
+	// Path is the path of the node the operation resulted in
+	Path string
 }
@@ -6,4 +6,6 @@ require (
 	bazil.org/fuse v0.0.0-20200117225306-7b5117fecadc
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.5.1
+	golang.org/x/crypto v0.0.0-20191202143827-86a70503ff7e
+	golang.org/x/sys v0.0.0-20191210023423-ac6580df4449
 )
@@ -0,0 +1,236 @@
+package resonatefuse
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/fuse/fuseutil"
+	"github.com/pkg/errors"
+)
+
+// Handle is an open file, it holds the file in the backend from Open until Release
+type Handle struct {
+	node  *File
+	file  BackendFile
+	flags fuse.OpenFlags
+}
+
+// NewHandle opens the origin file of node honouring the access mode, append
+// and truncate flags
+func NewHandle(node *File, flags fuse.OpenFlags) (*Handle, error) {
+	file, err := node.FFNode.Open(int(flags))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open handle to file (%v)", node.FFNode.Name())
+	}
+
+	return newHandle(node, file, flags), nil
+}
+
+// newHandle makes a handle of the file already opened with flags
+func newHandle(node *File, file BackendFile, flags fuse.OpenFlags) *Handle {
+	h := &Handle{
+		node:  node,
+		file:  file,
+		flags: flags,
+	}
+	node.FFNode.fs.track(h)
+
+	return h
+}
+
+// Read reads from the origin file at the requested offset
+func (h *Handle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	f := h.node.FFNode
+	log.Println("Reading", f.Name())
+
+	gr := &GeneralRequest{RequestHeader: h.node.FFNode.fs.requestHeader(ctx, req.Header), Path: f.Path(), Offset: req.Offset, Size: int64(req.Size), Flags: h.flags}
+	if err := f.fs.hook(ReadType, gr); err != nil {
+		return hookErr(err)
+	}
+
+	// A hook may change the size but the data still has to fit the response
+	if gr.Size < 0 {
+		return fuse.Errno(syscall.EINVAL)
+	}
+	size := int(gr.Size)
+	if size > cap(resp.Data) {
+		size = cap(resp.Data)
+	}
+
+	f.node.data.RLock()
+	resp.Data = resp.Data[:size]
+	n, err := h.file.ReadAt(resp.Data, gr.Offset)
+	resp.Data = resp.Data[:n]
+	f.node.data.RUnlock()
+
+	// Reaching the end of the file is only a short read
+	if err == io.EOF {
+		err = nil
+	}
+
+	f.fs.postHook(ReadType, gr, &GeneralResult{Err: err, Path: gr.Path})
+	if err != nil {
+		log.Println(err)
+		return fuse.EIO
+	}
+
+	return nil
+}
+
+// Write writes to the origin file at the requested offset (or its end when opened for appending)
+func (h *Handle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	f := h.node.FFNode
+	log.Println("Writing", f.Name())
+
+	gr := &GeneralRequest{RequestHeader: h.node.FFNode.fs.requestHeader(ctx, req.Header), Path: f.Path(), Data: req.Data, Offset: req.Offset, Flags: h.flags}
+	if err := f.fs.hook(WriteType, gr); err != nil {
+		return hookErr(err)
+	}
+
+	var n int
+	var err error
+
+	f.node.data.Lock()
+	if h.flags&fuse.OpenAppend != 0 {
+		n, err = h.file.Write(gr.Data)
+	} else {
+		n, err = h.file.WriteAt(gr.Data, gr.Offset)
+	}
+	f.node.data.Unlock()
+
+	f.fs.postHook(WriteType, gr, &GeneralResult{Err: err, Written: n, Path: gr.Path})
+
+	// The caller only knows about the data it asked to write, even if a hook changed it
+	if err != nil {
+		resp.Size = n
+		if resp.Size > len(req.Data) {
+			resp.Size = len(req.Data)
+		}
+		log.Println(err)
+		return fuse.EIO
+	}
+	resp.Size = len(req.Data)
+
+	return nil
+}
+
+// Flush is called every time a file descriptor of the handle is closed, writes
+// already reached the origin so only the hooks run (Fsync makes them durable)
+func (h *Handle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	f := h.node.FFNode
+	log.Println("Flushing", f.Name())
+
+	gr := &GeneralRequest{RequestHeader: h.node.FFNode.fs.requestHeader(ctx, req.Header), Path: f.Path(), Flags: h.flags}
+	if err := f.fs.hook(FlushType, gr); err != nil {
+		return hookErr(err)
+	}
+
+	f.fs.postHook(FlushType, gr, &GeneralResult{Path: gr.Path})
+
+	return nil
+}
+
+// Sync commits the contents of the origin file to stable storage
+func (h *Handle) Sync() error {
+	h.node.FFNode.node.data.RLock()
+	defer h.node.FFNode.node.data.RUnlock()
+
+	if err := h.file.Sync(); err != nil {
+		return errors.Wrapf(err, "could not sync file (%v)", h.node.FFNode.Name())
+	}
+
+	return nil
+}
+
+// Release closes the origin file once the last file descriptor of the handle is gone
+func (h *Handle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	f := h.node.FFNode
+	log.Println("Releasing", f.Name())
+
+	f.fs.untrack(h)
+	if err := h.file.Close(); err != nil {
+		log.Println(errors.Wrapf(err, "could not close file (%v)", f.Name()))
+	}
+
+	gr := &GeneralRequest{RequestHeader: h.node.FFNode.fs.requestHeader(ctx, req.Header), Path: f.Path(), Flags: req.Flags}
+	if err := f.fs.hook(ReleaseType, gr); err != nil {
+		return hookErr(err)
+	}
+
+	f.fs.postHook(ReleaseType, gr, &GeneralResult{Path: gr.Path})
+
+	return nil
+}
+
+// DirHandle is an open directory, it lists the directory itself (instead of
+// leaving it to fs.HandleReadDirAller) so hooks know who is reading it
+type DirHandle struct {
+	node *File
+
+	// dirents are the entries listed when the directory was read from its start
+	mu      sync.Mutex
+	dirents []byte
+}
+
+// Read returns the entries of the directory from the requested offset
+func (d *DirHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Reading from the start again (rewinddir) lists the directory again
+	if req.Offset == 0 || d.dirents == nil {
+		dirents, err := d.node.readDirAll(ctx, req.Header)
+		if err != nil {
+			return err
+		}
+
+		// Entries need an inode to be listed, the same one serving them would give
+		var parent uint64
+		if attr, err := d.node.FFNode.Attr(); err == nil {
+			parent = attr.Inode
+		}
+
+		data := make([]byte, 0)
+		for _, dirent := range dirents {
+			if dirent.Inode == 0 {
+				dirent.Inode = fs.GenerateDynamicInode(parent, dirent.Name)
+			}
+			data = fuse.AppendDirent(data, dirent)
+		}
+		d.dirents = data
+	}
+
+	fuseutil.HandleRead(req, resp, d.dirents)
+
+	return nil
+}
+
+// Release is called when a directory handle is closed
+func (d *DirHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	f := d.node.FFNode
+	log.Println("Releasing", f.Name())
+
+	gr := &GeneralRequest{RequestHeader: f.fs.requestHeader(ctx, req.Header), Path: f.Path(), Flags: req.Flags}
+	if err := f.fs.hook(ReleaseType, gr); err != nil {
+		return hookErr(err)
+	}
+
+	f.fs.postHook(ReleaseType, gr, &GeneralResult{Path: gr.Path})
+
+	return nil
+}
+
+var _ fs.Handle = (*DirHandle)(nil)
+var _ fs.HandleReader = (*DirHandle)(nil)
+var _ fs.HandleReleaser = (*DirHandle)(nil)
+
+var _ fs.Handle = (*Handle)(nil)
+var _ fs.HandleFlusher = (*Handle)(nil)
+var _ fs.HandleReader = (*Handle)(nil)
+var _ fs.HandleReleaser = (*Handle)(nil)
+var _ fs.HandleWriter = (*Handle)(nil)
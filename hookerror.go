@@ -0,0 +1,51 @@
+package resonatefuse
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/pkg/errors"
+)
+
+// HookError is returned by hooks to choose the errno the caller sees
+// (any other error returned by a hook is reported as EIO)
+type HookError struct {
+	// Code is the errno returned to the kernel (EACCES, EPERM, EROFS, ...)
+	Code syscall.Errno
+	// Message is optional and only written to the log
+	Message string
+}
+
+// NewHookError constructs a hook error failing the operation with code
+func NewHookError(code syscall.Errno, message string) *HookError {
+	return &HookError{Code: code, Message: message}
+}
+
+func (e *HookError) Error() string {
+	if e.Message == "" {
+		return e.Code.Error()
+	}
+
+	return fmt.Sprintf("%v: %v", e.Code.Error(), e.Message)
+}
+
+// Errno implements fuse.ErrorNumber
+func (e *HookError) Errno() fuse.Errno {
+	return fuse.Errno(e.Code)
+}
+
+// hookErr converts an error returned by a hook to the error handed to the kernel
+func hookErr(err error) error {
+	var he *HookError
+	if errors.As(err, &he) {
+		if he.Message != "" {
+			log.Println("hook failed operation:", he.Message)
+		}
+		return he.Errno()
+	}
+
+	log.Println("hook failed operation:", err)
+	return fuse.EIO
+}
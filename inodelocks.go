@@ -0,0 +1,85 @@
+package resonatefuse
+
+import (
+	"os"
+	"sync"
+)
+
+// inodeLocks hands out a lock for the contents of each file by inode, locks
+// are only kept while they are held or waited for
+type inodeLocks struct {
+	mu    sync.Mutex
+	locks map[uint64]*inodeLock
+}
+
+type inodeLock struct {
+	sync.RWMutex
+	users int
+}
+
+func newInodeLocks() *inodeLocks {
+	return &inodeLocks{locks: make(map[uint64]*inodeLock)}
+}
+
+// get returns the lock of the inode ino counting its user
+func (l *inodeLocks) get(ino uint64) *inodeLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, ok := l.locks[ino]
+	if !ok {
+		lock = &inodeLock{}
+		l.locks[ino] = lock
+	}
+	lock.users++
+
+	return lock
+}
+
+// put gives up the lock of the inode ino once its user is done with it
+func (l *inodeLocks) put(ino uint64, lock *inodeLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock.users--
+	if lock.users == 0 {
+		delete(l.locks, ino)
+	}
+}
+
+// lock locks the inode ino for changing its contents
+func (l *inodeLocks) lock(ino uint64) (unlock func()) {
+	lock := l.get(ino)
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+		l.put(ino, lock)
+	}
+}
+
+// rlock locks the inode ino for reading its contents
+func (l *inodeLocks) rlock(ino uint64) (unlock func()) {
+	lock := l.get(ino)
+	lock.RLock()
+
+	return func() {
+		lock.RUnlock()
+		l.put(ino, lock)
+	}
+}
+
+// fileInode returns the inode of the open file
+func fileInode(file *os.File) (uint64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	attr, err := fileAttr(info)
+	if err != nil {
+		return 0, err
+	}
+
+	return attr.Inode, nil
+}
@@ -0,0 +1,142 @@
+package resonatefuse
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/pkg/errors"
+)
+
+// LocalBackend stores files in a directory on the local disk, it is the
+// backend of volumes unless another one is given
+type LocalBackend struct {
+	dir string
+}
+
+// NewLocalBackend creates a backend storing files in the directory dir
+func NewLocalBackend(dir string) *LocalBackend {
+	return &LocalBackend{dir: dir}
+}
+
+// Dir returns the directory files are stored in
+func (b *LocalBackend) Dir() string {
+	return b.dir
+}
+
+func (b *LocalBackend) realify(path string) string {
+	return filepath.Join(b.dir, path)
+}
+
+func (b *LocalBackend) Stat(path string) (fuse.Attr, error) {
+	info, err := os.Lstat(b.realify(path))
+	if err != nil {
+		return fuse.Attr{}, err
+	}
+
+	return fileAttr(info)
+}
+
+func (b *LocalBackend) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(b.realify(path))
+}
+
+func (b *LocalBackend) Readlink(path string) (string, error) {
+	return os.Readlink(b.realify(path))
+}
+
+func (b *LocalBackend) Open(path string, flags int) (BackendFile, error) {
+	file, err := open(b.realify(path), flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (b *LocalBackend) Create(path string, mode os.FileMode, flags int) (BackendFile, error) {
+	file, err := os.OpenFile(b.realify(path), flags&openFlags|os.O_CREATE, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (b *LocalBackend) Mkdir(path string, mode os.FileMode) error {
+	return mkdir(b.realify(path), mode)
+}
+
+func (b *LocalBackend) Remove(path string) error {
+	return os.Remove(b.realify(path))
+}
+
+func (b *LocalBackend) Rename(oldPath, newPath string) error {
+	return os.Rename(b.realify(oldPath), b.realify(newPath))
+}
+
+func (b *LocalBackend) Link(oldPath, newPath string) error {
+	return os.Link(b.realify(oldPath), b.realify(newPath))
+}
+
+func (b *LocalBackend) Symlink(target, path string) error {
+	return os.Symlink(target, b.realify(path))
+}
+
+func (b *LocalBackend) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(b.realify(path), mode)
+}
+
+func (b *LocalBackend) Truncate(path string, size int64) error {
+	return os.Truncate(b.realify(path), size)
+}
+
+func (b *LocalBackend) Chtimes(path string, atime, mtime time.Time) error {
+	return lchtimes(b.realify(path), atime, mtime)
+}
+
+func (b *LocalBackend) Sync(path string) error {
+	return syncPath(b.realify(path))
+}
+
+func (b *LocalBackend) Lchown(path string, uid, gid int) error {
+	return os.Lchown(b.realify(path), uid, gid)
+}
+
+func (b *LocalBackend) Mknod(path string, mode os.FileMode, rdev uint32) error {
+	return mknod(b.realify(path), mode, rdev)
+}
+
+func (b *LocalBackend) Getxattr(path, name string) ([]byte, error) {
+	return getxattr(b.realify(path), name)
+}
+
+func (b *LocalBackend) Listxattr(path string) ([]string, error) {
+	return listxattr(b.realify(path))
+}
+
+func (b *LocalBackend) Setxattr(path, name string, value []byte, flags uint32) error {
+	return setxattr(b.realify(path), name, value, flags)
+}
+
+func (b *LocalBackend) Removexattr(path, name string) error {
+	return removexattr(b.realify(path), name)
+}
+
+func (b *LocalBackend) Statfs() (*StatfsRequest, error) {
+	st, err := statfs(b.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not statfs backend directory %v", b.dir)
+	}
+
+	return st, nil
+}
+
+var _ Backend = (*LocalBackend)(nil)
+var _ BackendSyncer = (*LocalBackend)(nil)
+var _ BackendChowner = (*LocalBackend)(nil)
+var _ BackendMknoder = (*LocalBackend)(nil)
+var _ BackendXattrer = (*LocalBackend)(nil)
+var _ BackendStatfser = (*LocalBackend)(nil)
@@ -0,0 +1,133 @@
+package resonatefuse
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	var writes []string
+	record := func(req *GeneralRequest) error {
+		writes = append(writes, string(req.Data))
+		return nil
+	}
+
+	rfs, err := NewFS("scratch", BackendOption(NewMemoryBackend()), GeneralOption(WriteType, record))
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	dir, err := rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Name: "joe", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	node, h, err := dir.(*File).Create(ctx, &fuse.CreateRequest{Name: "leo", Mode: 0640, Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("ali"), Offset: 2}, &fuse.WriteResponse{}))
+
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, 16)}
+	assert.Nil(t, h.(*Handle).Read(ctx, &fuse.ReadRequest{Size: 16}, resp))
+	assert.Equal(t, "\x00\x00ali", string(resp.Data))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+	assert.Equal(t, []string{"ali"}, writes)
+
+	a := fuse.Attr{}
+	assert.Nil(t, node.(*File).Attr(ctx, &a))
+	assert.Equal(t, uint64(5), a.Size)
+	assert.Equal(t, os.FileMode(0640), a.Mode)
+
+	// Truncating, chmod and times are kept by the backend
+	mtime := time.Unix(1000, 0)
+	setattr := &fuse.SetattrRequest{Valid: fuse.SetattrSize | fuse.SetattrMode | fuse.SetattrMtime, Size: 2, Mode: 0600, Mtime: mtime}
+	assert.Nil(t, node.(*File).Setattr(ctx, setattr, &fuse.SetattrResponse{}))
+	assert.Nil(t, node.(*File).Attr(ctx, &a))
+	assert.Equal(t, uint64(2), a.Size)
+	assert.Equal(t, os.FileMode(0600), a.Mode)
+	assert.Equal(t, mtime, a.Mtime)
+
+	// Hardlinks share their contents
+	link, err := rfs.root.Link(ctx, &fuse.LinkRequest{NewName: "ali"}, node)
+	assert.Nil(t, err)
+	h, err = link.(*File).Open(ctx, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Write(ctx, &fuse.WriteRequest{Data: []byte("muhammad")}, &fuse.WriteResponse{}))
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+	assert.Nil(t, node.(*File).Attr(ctx, &a))
+	assert.Equal(t, uint64(10), a.Size)
+	assert.Equal(t, uint32(2), a.Nlink)
+
+	_, err = rfs.root.Symlink(ctx, &fuse.SymlinkRequest{NewName: "sym", Target: "joe/leo"})
+	assert.Nil(t, err)
+	assert.Nil(t, rfs.root.Rename(ctx, &fuse.RenameRequest{OldName: "ali", NewName: "ali"}, dir))
+	assert.Nil(t, dir.(*File).Remove(ctx, &fuse.RemoveRequest{Name: "leo"}))
+	assert.Equal(t, syscall.ENOTEMPTY, rfs.root.Remove(ctx, &fuse.RemoveRequest{Name: "joe", Dir: true}))
+
+	_, err = rfs.root.Mknod(ctx, &fuse.MknodRequest{Name: "pipe", Mode: os.ModeNamedPipe | 0644})
+	assert.Nil(t, err)
+
+	link = dir.(*File).Child("ali")
+	assert.Nil(t, link.(*File).Setxattr(ctx, &fuse.SetxattrRequest{Name: "user.joe", Xattr: []byte("leo")}))
+	xresp := &fuse.GetxattrResponse{}
+	assert.Nil(t, link.(*File).Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.joe"}, xresp))
+	assert.Equal(t, "leo", string(xresp.Xattr))
+	assert.Equal(t, fuse.ErrNoXattr, link.(*File).Getxattr(ctx, &fuse.GetxattrRequest{Name: "user.ali"}, xresp))
+
+	// Nothing ever reaches the disk
+	_, err = os.Stat("scratch")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMemoryBackendPopulate(t *testing.T) {
+	b := NewMemoryBackend()
+	assert.Nil(t, b.Mkdir("joe", 0755))
+	_, err := b.Create("joe/leo", 0644, os.O_RDONLY)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Symlink("joe/leo", "ali"))
+	assert.Equal(t, syscall.EINVAL, b.Rename("joe", "joe/muhammad").(*os.PathError).Err)
+
+	rfs, err := NewFS("scratch", BackendOption(b))
+	assert.Nil(t, err)
+	assert.Equal(t, FILE, rfs.root.FFNode.Child("joe").Child("leo").Type())
+	assert.Equal(t, "joe/leo", rfs.root.FFNode.Child("ali").node.Link())
+}
+
+func TestMemoryBackendSparse(t *testing.T) {
+	b := NewMemoryBackend()
+	f, err := b.Create("joe", 0644, os.O_RDWR)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	// Only the pages written take up memory
+	assert.Nil(t, b.Truncate("joe", 1<<40))
+	_, err = f.WriteAt([]byte("leo"), 1<<40-1)
+	assert.Nil(t, err)
+	a, err := b.Stat("joe")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1<<40+2), a.Size)
+	assert.Equal(t, uint64(2*memoryPageSize/512), a.Blocks)
+
+	p := make([]byte, 5)
+	n, err := f.ReadAt(p, 1<<40-3)
+	assert.Nil(t, err)
+	assert.Equal(t, "\x00\x00leo", string(p[:n]))
+
+	// Shrinking drops pages and zeros the rest of the last one
+	assert.Nil(t, b.Truncate("joe", 1<<40))
+	assert.Nil(t, b.Truncate("joe", 1<<40+2))
+	n, err = f.ReadAt(p, 1<<40-1)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "l\x00\x00", string(p[:n]))
+	a, err = b.Stat("joe")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(memoryPageSize/512), a.Blocks)
+
+	// Offsets rewritten by hooks can not be negative
+	_, err = f.ReadAt(p, -1)
+	assert.Equal(t, syscall.EINVAL, err.(*os.PathError).Err)
+	_, err = f.WriteAt(p, -1)
+	assert.Equal(t, syscall.EINVAL, err.(*os.PathError).Err)
+}
@@ -0,0 +1,37 @@
+package resonatefuse
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// mknod creates a special file (fifo, socket or device) with the type and permissions of mode
+func mknod(name string, mode os.FileMode, rdev uint32) error {
+	perm := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= syscall.S_ISVTX
+	}
+
+	switch NodeTypeOf(mode) {
+	case FIFO:
+		perm |= syscall.S_IFIFO
+	case SOCKET:
+		perm |= syscall.S_IFSOCK
+	case CHAR:
+		perm |= syscall.S_IFCHR
+	case BLOCK:
+		perm |= syscall.S_IFBLK
+	default:
+		return errors.Errorf("could not make node %v of unsupported mode %v", name, mode)
+	}
+
+	return sysMknod(name, perm, rdev)
+}
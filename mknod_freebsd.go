@@ -0,0 +1,10 @@
+// +build freebsd
+
+package resonatefuse
+
+import "syscall"
+
+// sysMknod calls mknod(2) with the device number type of the platform
+func sysMknod(name string, mode uint32, rdev uint32) error {
+	return syscall.Mknod(name, mode, uint64(rdev))
+}
@@ -1,9 +1,243 @@
 package resonatefuse
 
-type Option func(*FS)
+import "github.com/pkg/errors"
 
-func GeneralOption(operation HookType, h GeneralHook) Option {
-	return func(rfs *FS) {
-		rfs.hooks[operation] = h
+type Option func(*FS) error
+
+// HookSetting changes which operations a hook is called for and how
+type HookSetting func(*hookSettings) error
+
+type hookSettings struct {
+	scope *pathScope
+	async *asyncQueue
+}
+
+type hookEntry struct {
+	hook GeneralHook
+	hookSettings
+}
+
+type postHookEntry struct {
+	hook GeneralPostHook
+	hookSettings
+}
+
+// Matching restricts a hook to operations touching a path matched by
+// patterns (for example "*.log", "build/**" or "!tmp/**"), the destination
+// of renames and links is matched as well
+func Matching(patterns ...string) HookSetting {
+	return func(hs *hookSettings) error {
+		scope, err := newPathScope(patterns...)
+		if err != nil {
+			return errors.Wrapf(err, "could not create hook scope")
+		}
+
+		hs.scope = scope
+		return nil
+	}
+}
+
+func newHookSettings(operation HookType, settings []HookSetting) (hookSettings, error) {
+	hs := hookSettings{}
+
+	if !operation.valid() {
+		return hs, errors.Errorf("unknown hook type (%v)", operation)
+	}
+
+	for _, setting := range settings {
+		if err := setting(&hs); err != nil {
+			return hs, errors.Wrapf(err, "could not apply setting to hook type (%v)", operation)
+		}
+	}
+
+	return hs, nil
+}
+
+// GeneralOption adds a hook that runs before operation, hooks of the same
+// operation run in the order they were given
+func GeneralOption(operation HookType, h GeneralHook, settings ...HookSetting) Option {
+	return func(rfs *FS) error {
+		if h == nil {
+			return errors.Errorf("nil hook given for hook type (%v)", operation)
+		}
+
+		hs, err := newHookSettings(operation, settings)
+		if err != nil {
+			return err
+		}
+
+		rfs.hooks[operation] = append(rfs.hooks[operation], hookEntry{hook: h, hookSettings: hs})
+		return nil
+	}
+}
+
+// GeneralPostOption adds a hook that runs after operation, hooks of the same
+// operation run in the order they were given
+func GeneralPostOption(operation HookType, h GeneralPostHook, settings ...HookSetting) Option {
+	return func(rfs *FS) error {
+		if h == nil {
+			return errors.Errorf("nil post hook given for hook type (%v)", operation)
+		}
+
+		hs, err := newHookSettings(operation, settings)
+		if err != nil {
+			return err
+		}
+
+		rfs.postHooks[operation] = append(rfs.postHooks[operation], postHookEntry{hook: h, hookSettings: hs})
+		return nil
+	}
+}
+
+// StatfsOption adds a hook that may change the capacity reported for the
+// volume, hooks run in the order they were given
+func StatfsOption(h StatfsHook) Option {
+	return func(rfs *FS) error {
+		if h == nil {
+			return errors.New("nil statfs hook given")
+		}
+
+		rfs.statfsHooks = append(rfs.statfsHooks, h)
+		return nil
+	}
+}
+
+// VirtualCapacity reports the volume as size bytes big, space used in the
+// origin is still counted against it
+func VirtualCapacity(size uint64) Option {
+	return StatfsOption(func(req *StatfsRequest) error {
+		unit := uint64(req.Frsize)
+		if unit == 0 {
+			unit = uint64(req.Bsize)
+		}
+		if unit == 0 {
+			return errors.New("could not compute virtual capacity without a block size")
+		}
+
+		used := req.Blocks - req.Bfree
+		req.Blocks = size / unit
+
+		req.Bfree = 0
+		if req.Blocks > used {
+			req.Bfree = req.Blocks - used
+		}
+
+		if req.Bavail > req.Bfree {
+			req.Bavail = req.Bfree
+		}
+
+		return nil
+	})
+}
+
+// OnCreate adds a hook that runs before files are created
+func OnCreate(h CreateHook, settings ...HookSetting) Option {
+	if h == nil {
+		return GeneralOption(CreateType, nil)
+	}
+
+	return GeneralOption(CreateType, func(gr *GeneralRequest) error {
+		req := &CreateRequest{RequestHeader: gr.RequestHeader, Path: gr.Path, Name: gr.Name, Mode: gr.Mode}
+		err := h(req)
+		gr.Name, gr.Mode = req.Name, req.Mode
+		return err
+	}, settings...)
+}
+
+// OnWrite adds a hook that runs before files are written
+func OnWrite(h WriteHook, settings ...HookSetting) Option {
+	if h == nil {
+		return GeneralOption(WriteType, nil)
+	}
+
+	return GeneralOption(WriteType, func(gr *GeneralRequest) error {
+		req := &WriteRequest{RequestHeader: gr.RequestHeader, Path: gr.Path, Data: gr.Data, Offset: gr.Offset}
+		err := h(req)
+		gr.Data, gr.Offset = req.Data, req.Offset
+		return err
+	}, settings...)
+}
+
+// OnRemove adds a hook that runs before files and directories are removed
+func OnRemove(h RemoveHook, settings ...HookSetting) Option {
+	if h == nil {
+		return GeneralOption(RemoveType, nil)
+	}
+
+	return GeneralOption(RemoveType, func(gr *GeneralRequest) error {
+		req := &RemoveRequest{RequestHeader: gr.RequestHeader, Path: gr.Path, Name: gr.Name}
+		err := h(req)
+		gr.Name = req.Name
+		return err
+	}, settings...)
+}
+
+// OnRename adds a hook that runs before files and directories are renamed
+func OnRename(h RenameHook, settings ...HookSetting) Option {
+	if h == nil {
+		return GeneralOption(RenameType, nil)
 	}
+
+	return GeneralOption(RenameType, func(gr *GeneralRequest) error {
+		req := &RenameRequest{RequestHeader: gr.RequestHeader, Path: gr.Path, OldName: gr.OldName, NewName: gr.NewName, NewDir: gr.NewDir}
+		err := h(req)
+		gr.OldName, gr.NewName = req.OldName, req.NewName
+		return err
+	}, settings...)
+}
+
+// OnMkdir adds a hook that runs before directories are created
+func OnMkdir(h MkdirHook, settings ...HookSetting) Option {
+	if h == nil {
+		return GeneralOption(MkdirType, nil)
+	}
+
+	return GeneralOption(MkdirType, func(gr *GeneralRequest) error {
+		req := &MkdirRequest{RequestHeader: gr.RequestHeader, Path: gr.Path, Name: gr.Name, Mode: gr.Mode}
+		err := h(req)
+		gr.Name, gr.Mode = req.Name, req.Mode
+		return err
+	}, settings...)
+}
+
+// OnLink adds a hook that runs before hard links are created
+func OnLink(h LinkHook, settings ...HookSetting) Option {
+	if h == nil {
+		return GeneralOption(LinkType, nil)
+	}
+
+	return GeneralOption(LinkType, func(gr *GeneralRequest) error {
+		req := &LinkRequest{RequestHeader: gr.RequestHeader, Path: gr.Path, NewName: gr.NewName, Old: gr.Old}
+		err := h(req)
+		gr.NewName = req.NewName
+		return err
+	}, settings...)
+}
+
+// OnSymlink adds a hook that runs before symlinks are created
+func OnSymlink(h SymlinkHook, settings ...HookSetting) Option {
+	if h == nil {
+		return GeneralOption(SymlinkType, nil)
+	}
+
+	return GeneralOption(SymlinkType, func(gr *GeneralRequest) error {
+		req := &SymlinkRequest{RequestHeader: gr.RequestHeader, Path: gr.Path, Target: gr.Target, NewName: gr.NewName}
+		err := h(req)
+		gr.Target, gr.NewName = req.Target, req.NewName
+		return err
+	}, settings...)
+}
+
+// OnSetattr adds a hook that runs before attributes are changed
+func OnSetattr(h SetattrHook, settings ...HookSetting) Option {
+	if h == nil {
+		return GeneralOption(SetattrType, nil)
+	}
+
+	return GeneralOption(SetattrType, func(gr *GeneralRequest) error {
+		req := &SetattrRequest{RequestHeader: gr.RequestHeader, Path: gr.Path, Valid: gr.Valid, Mode: gr.Mode, Size: uint64(gr.Size), Atime: gr.Atime, Mtime: gr.Mtime, Uid: gr.Uid, Gid: gr.Gid}
+		err := h(req)
+		gr.Valid, gr.Mode, gr.Size, gr.Atime, gr.Mtime, gr.Uid, gr.Gid = req.Valid, req.Mode, int64(req.Size), req.Atime, req.Mtime, req.Uid, req.Gid
+		return err
+	}, settings...)
 }
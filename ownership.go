@@ -0,0 +1,62 @@
+package resonatefuse
+
+type chownMode uint8
+
+const (
+	chownPassthrough chownMode = iota
+	chownDeny
+	chownMap
+)
+
+// chownPolicy decides what happens to ownership changes made through the volume
+type chownPolicy struct {
+	mode chownMode
+	uid  uint32
+	gid  uint32
+}
+
+// owner returns the owner to apply to the origin for a chown, -1 leaves an id unchanged
+func (p chownPolicy) owner(req *SetattrRequest) (uid, gid int) {
+	uid, gid = -1, -1
+
+	if req.Valid.Uid() {
+		uid = int(req.Uid)
+		if p.mode == chownMap {
+			uid = int(p.uid)
+		}
+	}
+
+	if req.Valid.Gid() {
+		gid = int(req.Gid)
+		if p.mode == chownMap {
+			gid = int(p.gid)
+		}
+	}
+
+	return uid, gid
+}
+
+// ChownPassthrough applies ownership changes to the origin as requested (the
+// default), they fail with EPERM unless the daemon is privileged enough
+func ChownPassthrough() Option {
+	return func(rfs *FS) error {
+		rfs.chown = chownPolicy{mode: chownPassthrough}
+		return nil
+	}
+}
+
+// ChownDeny fails every ownership change with EPERM
+func ChownDeny() Option {
+	return func(rfs *FS) error {
+		rfs.chown = chownPolicy{mode: chownDeny}
+		return nil
+	}
+}
+
+// ChownTo applies ownership changes to the origin as the fixed owner uid and gid
+func ChownTo(uid, gid uint32) Option {
+	return func(rfs *FS) error {
+		rfs.chown = chownPolicy{mode: chownMap, uid: uid, gid: gid}
+		return nil
+	}
+}
@@ -0,0 +1,199 @@
+package resonatefuse
+
+import (
+	"context"
+	"os"
+	"syscall"
+
+	"bazil.org/fuse"
+)
+
+// Access bits as used by access(2)
+const (
+	permExec  uint32 = 1
+	permWrite uint32 = 2
+	permRead  uint32 = 4
+)
+
+// permitted reports whether the caller uid (in groups gids) may access a
+// file with attributes a as described by mask
+func permitted(a *fuse.Attr, uid uint32, gids []uint32, mask uint32) bool {
+	if uid == 0 {
+		// root may do anything but execute files nobody may execute
+		return mask&permExec == 0 || a.Mode.IsDir() || a.Mode&0111 != 0
+	}
+
+	perm := uint32(a.Mode.Perm())
+	switch {
+	case uid == a.Uid:
+		perm >>= 6
+	case memberOf(a.Gid, gids):
+		perm >>= 3
+	}
+
+	return perm&mask == mask
+}
+
+func memberOf(gid uint32, gids []uint32) bool {
+	for _, g := range gids {
+		if g == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// openMask returns the access needed to open a file with flags
+func openMask(flags fuse.OpenFlags) uint32 {
+	switch {
+	case flags.IsReadWrite():
+		return permRead | permWrite
+	case flags.IsWriteOnly():
+		return permWrite
+	default:
+		return permRead
+	}
+}
+
+// check fails with EACCES unless the caller in header may access the file as
+// described by mask (only when permissions are checked by the volume)
+func (f *File) check(ctx context.Context, header fuse.Header, mask uint32) error {
+	if !f.FFNode.fs.checkPermissions {
+		return nil
+	}
+
+	a := fuse.Attr{}
+	if err := f.Attr(ctx, &a); err != nil {
+		return err
+	}
+
+	if !permitted(&a, header.Uid, callerGids(header), mask) {
+		return fuse.Errno(syscall.EACCES)
+	}
+
+	return nil
+}
+
+// callerGids returns the groups of the caller in header
+func callerGids(header fuse.Header) []uint32 {
+	return append(callerGroups(header.Pid), header.Gid)
+}
+
+// owns fails with EPERM unless the caller in header owns the file (or is root)
+func (f *File) owns(ctx context.Context, header fuse.Header) error {
+	if !f.FFNode.fs.checkPermissions || header.Uid == 0 {
+		return nil
+	}
+
+	a := fuse.Attr{}
+	if err := f.Attr(ctx, &a); err != nil {
+		return err
+	}
+
+	if a.Uid != header.Uid {
+		return fuse.EPERM
+	}
+
+	return nil
+}
+
+// checkSetattr checks the caller may change the attributes marked valid by
+// req, modes given to files of groups the caller is not in lose their setuid
+// and setgid bits
+func (f *File) checkSetattr(ctx context.Context, req *fuse.SetattrRequest) error {
+	if req.Valid.Mode() || req.Valid.Uid() || req.Valid.Gid() {
+		if err := f.owns(ctx, req.Header); err != nil {
+			return err
+		}
+		if err := f.checkOwner(ctx, req); err != nil {
+			return err
+		}
+	}
+
+	if req.Valid.Size() {
+		if err := f.check(ctx, req.Header, permWrite); err != nil {
+			return err
+		}
+	}
+
+	if req.Valid.Atime() || req.Valid.Mtime() {
+		// Anyone who may write can set the times to now, explicit times need the owner
+		err := f.owns(ctx, req.Header)
+		now := (!req.Valid.Atime() || req.Valid.AtimeNow()) && (!req.Valid.Mtime() || req.Valid.MtimeNow())
+		if err != nil && now {
+			err = f.check(ctx, req.Header, permWrite)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkOwner fails with EPERM unless the caller may give the file the owner
+// and group in req, only root changes owners and owners only pick groups they
+// are in (only when permissions are checked by the volume)
+func (f *File) checkOwner(ctx context.Context, req *fuse.SetattrRequest) error {
+	if !f.FFNode.fs.checkPermissions || req.Header.Uid == 0 {
+		return nil
+	}
+
+	a := fuse.Attr{}
+	if err := f.Attr(ctx, &a); err != nil {
+		return err
+	}
+
+	if req.Valid.Uid() && req.Uid != a.Uid {
+		return fuse.EPERM
+	}
+
+	gids := callerGids(req.Header)
+	gid := a.Gid
+	if req.Valid.Gid() {
+		gid = req.Gid
+		if gid != a.Gid && !memberOf(gid, gids) {
+			return fuse.EPERM
+		}
+	}
+
+	if req.Valid.Mode() && !memberOf(gid, gids) {
+		req.Mode &^= os.ModeSetuid | os.ModeSetgid
+	}
+
+	return nil
+}
+
+// Access checks whether the caller may access the file as asked by access(2)
+func (f *File) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	return f.check(ctx, req.Header, req.Mask)
+}
+
+// CheckPermissions makes the volume check every operation against the mode
+// and owner of the files and the uid and groups of the calling process
+func CheckPermissions() Option {
+	return func(rfs *FS) error {
+		rfs.checkPermissions = true
+		return nil
+	}
+}
+
+// DefaultPermissions mounts the volume with default_permissions so the kernel
+// checks permissions itself before calling the volume
+func DefaultPermissions() Option {
+	return func(rfs *FS) error {
+		rfs.mountOptions = append(rfs.mountOptions, fuse.DefaultPermissions())
+		return nil
+	}
+}
+
+// AllowOther mounts the volume with allow_other so users other than the one
+// running the volume can access it (combine with CheckPermissions or
+// DefaultPermissions)
+func AllowOther() Option {
+	return func(rfs *FS) error {
+		rfs.mountOptions = append(rfs.mountOptions, fuse.AllowOther())
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package resonatefuse
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermitted(t *testing.T) {
+	a := &fuse.Attr{Mode: 0640, Uid: 1000, Gid: 100}
+
+	tt := []struct {
+		uid   uint32
+		gids  []uint32
+		mask  uint32
+		allow bool
+	}{
+		{uid: 1000, mask: permRead | permWrite, allow: true},
+		{uid: 1000, mask: permExec, allow: false},
+		{uid: 1001, gids: []uint32{100}, mask: permRead, allow: true},
+		{uid: 1001, gids: []uint32{100}, mask: permWrite, allow: false},
+		{uid: 1001, gids: []uint32{200}, mask: permRead, allow: false},
+		{uid: 0, mask: permRead | permWrite, allow: true},
+		{uid: 0, mask: permExec, allow: false},
+	}
+
+	for _, tc := range tt {
+		assert.Equal(t, tc.allow, permitted(a, tc.uid, tc.gids, tc.mask), "uid %v gids %v mask %v", tc.uid, tc.gids, tc.mask)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	rfs, cleanup := newTestFS(t, CheckPermissions())
+	defer cleanup()
+
+	owner := fuse.Header{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	other := fuse.Header{Uid: owner.Uid + 1, Gid: owner.Gid + 1}
+
+	ctx := context.Background()
+	node, err := rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Header: owner, Name: "joe", Mode: os.ModeDir | 0700})
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chmod(rfs.realify("joe"), 0700))
+
+	_, err = node.(*File).Lookup(ctx, &fuse.LookupRequest{Header: other, Name: "leo"}, &fuse.LookupResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EACCES), fuse.ToErrno(err))
+
+	_, _, err = node.(*File).Create(ctx, &fuse.CreateRequest{Header: other, Name: "leo", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EACCES), fuse.ToErrno(err))
+
+	assert.Equal(t, fuse.EPERM, fuse.ToErrno(node.(*File).Setattr(ctx, &fuse.SetattrRequest{Header: other, Valid: fuse.SetattrMode, Mode: 0777}, &fuse.SetattrResponse{})))
+	assert.Nil(t, node.(*File).Access(ctx, &fuse.AccessRequest{Header: owner, Mask: permRead | permWrite | permExec}))
+	assert.NotNil(t, node.(*File).Access(ctx, &fuse.AccessRequest{Header: other, Mask: permRead}))
+}
+
+func TestCheckOwner(t *testing.T) {
+	rfs, cleanup := newTestFS(t, CheckPermissions())
+	defer cleanup()
+
+	if os.Getuid() != 0 {
+		t.Skip("giving files to other users needs root")
+	}
+
+	ctx := context.Background()
+	_, h, err := rfs.root.Create(ctx, &fuse.CreateRequest{Name: "joe", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*Handle).Release(ctx, &fuse.ReleaseRequest{}))
+	assert.Nil(t, os.Lchown(rfs.realify("joe"), 1000, 3000))
+	node := rfs.root.Child("joe")
+
+	// Owners can not give files away or into groups they are not in
+	owner := fuse.Header{Uid: 1000, Gid: 1000}
+	tt := []struct {
+		valid fuse.SetattrValid
+		uid   uint32
+		gid   uint32
+		err   error
+	}{
+		{valid: fuse.SetattrUid, uid: 1001, err: fuse.EPERM},
+		{valid: fuse.SetattrUid, uid: 1000},
+		{valid: fuse.SetattrGid, gid: 2000, err: fuse.EPERM},
+		{valid: fuse.SetattrGid, gid: 3000},
+		{valid: fuse.SetattrGid, gid: 1000},
+	}
+
+	for _, tc := range tt {
+		req := &fuse.SetattrRequest{Header: owner, Valid: tc.valid, Uid: tc.uid, Gid: tc.gid}
+		assert.Equal(t, tc.err, node.Setattr(ctx, req, &fuse.SetattrResponse{}), "valid %v uid %v gid %v", tc.valid, tc.uid, tc.gid)
+	}
+
+	info, err := os.Stat(rfs.realify("joe"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1000), info.Sys().(*syscall.Stat_t).Uid)
+	assert.Equal(t, uint32(1000), info.Sys().(*syscall.Stat_t).Gid)
+
+	// Files of groups the caller is not in do not keep setuid and setgid
+	assert.Nil(t, os.Lchown(rfs.realify("joe"), 1000, 3000))
+	req := &fuse.SetattrRequest{Header: owner, Valid: fuse.SetattrMode, Mode: os.ModeSetuid | os.ModeSetgid | 0755}
+	assert.Nil(t, node.Setattr(ctx, req, &fuse.SetattrResponse{}))
+	info, err = os.Stat(rfs.realify("joe"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode())
+
+	assert.Nil(t, os.Lchown(rfs.realify("joe"), 1000, 1000))
+	req.Mode = os.ModeSetuid | os.ModeSetgid | 0755
+	assert.Nil(t, node.Setattr(ctx, req, &fuse.SetattrResponse{}))
+	info, err = os.Stat(rfs.realify("joe"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeSetuid|os.ModeSetgid|0755, info.Mode())
+
+	// Root changes owners freely
+	root := &fuse.SetattrRequest{Valid: fuse.SetattrUid | fuse.SetattrGid, Uid: 1001, Gid: 2000}
+	assert.Nil(t, node.Setattr(ctx, root, &fuse.SetattrResponse{}))
+}
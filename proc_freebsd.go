@@ -0,0 +1,21 @@
+// +build freebsd
+
+package resonatefuse
+
+// callerGroups returns the supplementary groups of the process pid
+// NOTE: Only the primary group of callers is known on freebsd yet
+func callerGroups(pid uint32) []uint32 {
+	return nil
+}
+
+// processStart returns when the process pid started
+// NOTE: Processes of callers are not resolved on freebsd yet
+func processStart(pid uint32) (uint64, error) {
+	return 0, nil
+}
+
+// callerProcess resolves the process pid
+// NOTE: Processes of callers are not resolved on freebsd yet
+func callerProcess(pid uint32) (*Process, error) {
+	return nil, nil
+}
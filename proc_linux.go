@@ -0,0 +1,132 @@
+// +build linux
+
+package resonatefuse
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// procStatus returns the value of key in /proc/pid/status
+func procStatus(pid uint32, key string) (string, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%v/status", pid))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, key+":") {
+			return strings.TrimSpace(strings.TrimPrefix(line, key+":")), nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// processStart returns when the process pid started (in clock ticks since boot)
+func processStart(pid uint32) (uint64, error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	return procStartTime(string(stat))
+}
+
+// procStartTime returns the start time (field 22) from the contents of
+// /proc/pid/stat, fields are counted after the command name as it may hold
+// spaces and parentheses
+func procStartTime(stat string) (uint64, error) {
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, errors.Errorf("could not find command in process stat")
+	}
+
+	// The fields after the command start at the third one
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return 0, errors.Errorf("could not find start time in process stat")
+	}
+
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// callerGroups returns the supplementary groups of the process pid
+func callerGroups(pid uint32) []uint32 {
+	groups, err := procStatus(pid, "Groups")
+	if err != nil {
+		return nil
+	}
+
+	gids := make([]uint32, 0)
+	for _, field := range strings.Fields(groups) {
+		gid, err := strconv.ParseUint(field, 10, 32)
+		if err == nil {
+			gids = append(gids, uint32(gid))
+		}
+	}
+
+	return gids
+}
+
+// callerProcess resolves the process pid from /proc, details hidden from the
+// daemon (such as the executable of other users' processes) are left empty
+func callerProcess(pid uint32) (*Process, error) {
+	ppid, err := procStatus(pid, "PPid")
+	if err != nil {
+		return nil, err
+	}
+
+	process := &Process{}
+	if parent, err := strconv.ParseUint(ppid, 10, 32); err == nil {
+		process.PPid = uint32(parent)
+	}
+
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%v/exe", pid)); err == nil {
+		process.Exe = exe
+	}
+
+	if cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/cmdline", pid)); err == nil && len(cmdline) > 0 {
+		for _, arg := range bytes.Split(bytes.TrimSuffix(cmdline, []byte{0}), []byte{0}) {
+			process.Cmdline = append(process.Cmdline, string(arg))
+		}
+	}
+
+	if cgroup, err := ioutil.ReadFile(fmt.Sprintf("/proc/%v/cgroup", pid)); err == nil {
+		process.Cgroup = procCgroup(string(cgroup))
+	}
+
+	return process, nil
+}
+
+// procCgroup returns the cgroup path from the contents of /proc/pid/cgroup,
+// preferring the unified (v2) hierarchy over the first v1 hierarchy
+func procCgroup(contents string) string {
+	cgroup := ""
+	for _, line := range strings.Split(strings.TrimSpace(contents), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		if fields[0] == "0" && fields[1] == "" {
+			return fields[2]
+		}
+
+		if cgroup == "" {
+			cgroup = fields[2]
+		}
+	}
+
+	return cgroup
+}
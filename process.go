@@ -0,0 +1,109 @@
+package resonatefuse
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// processCacheSize bounds the number of processes remembered by a volume
+const processCacheSize = 1024
+
+// Process describes the process behind a caller as found in /proc when the
+// operation was made, it is shared between requests and must not be modified
+type Process struct {
+	Exe     string
+	Cmdline []string
+	Cgroup  string
+	PPid    uint32
+}
+
+type processEntry struct {
+	process *Process
+	// start is when the process started, it tells a reused pid apart
+	start   uint64
+	expires time.Time
+}
+
+// processCache resolves callers to their process and remembers them per pid
+// for ttl, an entry is only used while the pid belongs to the same process
+type processCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[uint32]processEntry
+}
+
+func newProcessCache(ttl time.Duration) *processCache {
+	return &processCache{ttl: ttl, entries: make(map[uint32]processEntry)}
+}
+
+// lookup returns the process pid, nil when it cannot be resolved
+func (c *processCache) lookup(pid uint32) *Process {
+	if pid == 0 {
+		// Operations made by the kernel itself
+		return nil
+	}
+
+	// Without its start time the process cannot be told from one that had its pid before
+	start, err := processStart(pid)
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[pid]
+	c.mu.Unlock()
+	if ok && entry.start == start && now.Before(entry.expires) {
+		return entry.process
+	}
+
+	process, err := callerProcess(pid)
+	if err != nil || process == nil {
+		return nil
+	}
+
+	// The pid may have been reused while the process was being resolved
+	if again, err := processStart(pid); err != nil || again != start {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= processCacheSize {
+		c.prune(now)
+	}
+	c.entries[pid] = processEntry{process: process, start: start, expires: now.Add(c.ttl)}
+
+	return process
+}
+
+// prune forgets expired processes, or every process when none expired
+func (c *processCache) prune(now time.Time) {
+	for pid, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, pid)
+		}
+	}
+
+	if len(c.entries) >= processCacheSize {
+		c.entries = make(map[uint32]processEntry)
+	}
+}
+
+// CallerProcesses adds the process of callers to the Caller of hook requests,
+// processes are resolved from /proc and remembered for ttl (a pid reused by
+// another process is resolved again)
+func CallerProcesses(ttl time.Duration) Option {
+	return func(rfs *FS) error {
+		if ttl <= 0 {
+			return errors.Errorf("caller processes need a positive cache duration (got %v)", ttl)
+		}
+
+		rfs.processes = newProcessCache(ttl)
+		return nil
+	}
+}
@@ -0,0 +1,72 @@
+// +build linux
+
+package resonatefuse
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallerProcesses(t *testing.T) {
+	var processes []*Process
+	hook := func(req *GeneralRequest) error {
+		processes = append(processes, req.Caller.Process)
+		return nil
+	}
+
+	rfs, cleanup := newTestFS(t, CallerProcesses(time.Minute), GeneralOption(MkdirType, hook))
+	defer cleanup()
+
+	ctx := context.Background()
+	header := fuse.Header{Pid: uint32(os.Getpid())}
+	_, err := rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Header: header, Name: "joe", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	_, err = rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Header: header, Name: "leo", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+
+	// Operations made by the kernel have no process
+	_, err = rfs.root.Mkdir(ctx, &fuse.MkdirRequest{Name: "ali", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+
+	exe, err := os.Executable()
+	assert.Nil(t, err)
+
+	assert.Len(t, processes, 3)
+	assert.NotNil(t, processes[0])
+	assert.Equal(t, exe, processes[0].Exe)
+	assert.Equal(t, os.Args, processes[0].Cmdline)
+	assert.Equal(t, uint32(os.Getppid()), processes[0].PPid)
+	assert.True(t, processes[0] == processes[1], "processes are cached per pid")
+	assert.Nil(t, processes[2])
+
+	// A pid that started another process is resolved again
+	pid := uint32(os.Getpid())
+	stale := &Process{Exe: "/bin/stale"}
+	rfs.processes.entries[pid] = processEntry{process: stale, start: 1, expires: time.Now().Add(time.Minute)}
+	assert.Equal(t, exe, rfs.processes.lookup(pid).Exe)
+
+	_, err = NewFS(rfs.Origin(), CallerProcesses(0))
+	assert.NotNil(t, err)
+}
+
+func TestProcStartTime(t *testing.T) {
+	stat := "42 (my (odd) cmd) S 1 42 42 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 12345 1000 100"
+	start, err := procStartTime(stat)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(12345), start)
+
+	_, err = procStartTime("42 (cmd) S 1")
+	assert.NotNil(t, err)
+}
+
+func TestProcCgroup(t *testing.T) {
+	assert.Equal(t, "/user.slice/joe.scope", procCgroup("0::/user.slice/joe.scope\n"))
+	assert.Equal(t, "/unified", procCgroup("12:cpu,cpuacct:/v1\n0::/unified\n"))
+	assert.Equal(t, "/v1", procCgroup("12:cpu,cpuacct:/v1\n11:memory:/other\n"))
+	assert.Equal(t, "", procCgroup(""))
+}
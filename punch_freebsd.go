@@ -0,0 +1,11 @@
+// +build freebsd
+
+package resonatefuse
+
+import "os"
+
+// punchHole frees the disk space of length bytes of file at off, FreeBSD
+// can not punch holes so the space stays allocated
+func punchHole(file *os.File, off, length int64) error {
+	return nil
+}
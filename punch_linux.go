@@ -0,0 +1,21 @@
+// +build linux
+
+package resonatefuse
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// punchHole frees the disk space of length bytes of file at off, the size
+// of the file stays the same and filesystems that can not punch holes are
+// left alone
+func punchHole(file *os.File, off, length int64) error {
+	err := unix.Fallocate(int(file.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, off, length)
+	if err == unix.EOPNOTSUPP || err == unix.ENOSYS {
+		return nil
+	}
+
+	return err
+}
@@ -3,10 +3,14 @@ package resonatefuse
 import (
 	"os"
 	"time"
+
+	"bazil.org/fuse"
 )
 
 type CreateHook func(*CreateRequest) error
 type CreateRequest struct {
+	RequestHeader
+
 	Path string
 	Name string
 	Mode os.FileMode
@@ -14,6 +18,8 @@ type CreateRequest struct {
 
 type WriteHook func(*WriteRequest) error
 type WriteRequest struct {
+	RequestHeader
+
 	Path   string
 	Data   []byte
 	Offset int64
@@ -21,12 +27,16 @@ type WriteRequest struct {
 
 type RemoveHook func(*RemoveRequest) error
 type RemoveRequest struct {
+	RequestHeader
+
 	Path string
 	Name string
 }
 
 type RenameHook func(*RenameRequest) error
 type RenameRequest struct {
+	RequestHeader
+
 	Path    string
 	OldName string
 	NewName string
@@ -35,6 +45,8 @@ type RenameRequest struct {
 
 type MkdirHook func(*MkdirRequest) error
 type MkdirRequest struct {
+	RequestHeader
+
 	Path string
 	Name string
 	Mode os.FileMode
@@ -42,6 +54,8 @@ type MkdirRequest struct {
 
 type LinkHook func(*LinkRequest) error
 type LinkRequest struct {
+	RequestHeader
+
 	Path    string
 	NewName string
 	Old     string
@@ -49,6 +63,8 @@ type LinkRequest struct {
 
 type SymlinkHook func(*SymlinkRequest) error
 type SymlinkRequest struct {
+	RequestHeader
+
 	Path    string
 	Target  string
 	NewName string
@@ -56,8 +72,29 @@ type SymlinkRequest struct {
 
 type SetattrHook func(*SetattrRequest) error
 type SetattrRequest struct {
+	RequestHeader
+
 	Path  string
+	Valid fuse.SetattrValid
 	Mode  os.FileMode
+	Size  uint64
 	Atime time.Time
 	Mtime time.Time
+	Uid   uint32
+	Gid   uint32
+}
+
+// StatfsHook may change the capacity reported for the volume
+type StatfsHook func(*StatfsRequest) error
+type StatfsRequest struct {
+	RequestHeader
+
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Bsize   uint32
+	Namelen uint32
+	Frsize  uint32
 }
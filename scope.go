@@ -0,0 +1,127 @@
+package resonatefuse
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pathPattern is a single compiled glob of a scope
+type pathPattern struct {
+	negate   bool
+	anchored bool
+	segments []string
+}
+
+// pathScope restricts a hook to the paths matched by its patterns
+type pathScope struct {
+	patterns []pathPattern
+}
+
+// newPathScope compiles gitignore like patterns: patterns without a slash
+// (such as "*.log") match the name at any depth, patterns containing a slash
+// are relative to the volume root, "**" matches any number of directories and
+// a leading "!" excludes paths matched by earlier patterns. The last pattern
+// that matches a path decides.
+func newPathScope(patterns ...string) (*pathScope, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("scope needs at least one pattern")
+	}
+
+	scope := &pathScope{}
+	for _, pattern := range patterns {
+		pp := pathPattern{}
+
+		if strings.HasPrefix(pattern, "!") {
+			pp.negate = true
+			pattern = pattern[1:]
+		}
+
+		pattern = strings.TrimSuffix(pattern, "/")
+		pp.anchored = strings.Contains(pattern, "/")
+		pp.segments = splitPath(strings.TrimPrefix(pattern, "/"))
+
+		if len(pp.segments) == 0 {
+			return nil, errors.Errorf("empty pattern in scope")
+		}
+
+		for _, segment := range pp.segments {
+			if _, err := filepath.Match(segment, ""); err != nil {
+				return nil, errors.Wrapf(err, "invalid pattern (%v)", pattern)
+			}
+		}
+
+		scope.patterns = append(scope.patterns, pp)
+	}
+
+	return scope, nil
+}
+
+// Match reports whether path is within the scope
+func (s *pathScope) Match(path string) bool {
+	if s == nil {
+		return true
+	}
+
+	segments := splitPath(path)
+
+	// A scope made of exclusions only starts out including everything
+	matched := s.patterns[0].negate
+	for _, pattern := range s.patterns {
+		if pattern.match(segments) {
+			matched = !pattern.negate
+		}
+	}
+
+	return matched
+}
+
+// MatchRequest reports whether any of the paths touched by req is within the scope
+func (s *pathScope) MatchRequest(req *GeneralRequest) bool {
+	if s == nil {
+		return true
+	}
+
+	for _, path := range req.paths() {
+		if s.Match(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p pathPattern) match(path []string) bool {
+	if p.anchored {
+		return matchSegments(p.segments, path)
+	}
+
+	// Unanchored patterns are matched against the name at any depth
+	return len(path) > 0 && matchSegments(p.segments, path[len(path)-1:])
+}
+
+func matchSegments(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchSegments(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(path) == 0 {
+		return false
+	}
+
+	if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+		return false
+	}
+
+	return matchSegments(pattern[1:], path[1:])
+}
@@ -0,0 +1,49 @@
+package resonatefuse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathScope(t *testing.T) {
+	tt := []struct {
+		patterns []string
+		path     string
+		match    bool
+	}{
+		{patterns: []string{"*.log"}, path: "joe.log", match: true},
+		{patterns: []string{"*.log"}, path: "joe/ali/leo.log", match: true},
+		{patterns: []string{"*.log"}, path: "joe.log/leo", match: false},
+		{patterns: []string{"build/**"}, path: "build", match: true},
+		{patterns: []string{"build/**"}, path: "build/joe/leo.o", match: true},
+		{patterns: []string{"build/**"}, path: "src/build/leo.o", match: false},
+		{patterns: []string{"**/build/*.o"}, path: "src/build/leo.o", match: true},
+		{patterns: []string{"!tmp/**"}, path: "tmp/joe", match: false},
+		{patterns: []string{"!tmp/**"}, path: "joe", match: true},
+		{patterns: []string{"*.log", "!tmp/**"}, path: "tmp/joe.log", match: false},
+		{patterns: []string{"*.log", "!tmp/**"}, path: "joe.log", match: true},
+		{patterns: []string{"*.log", "!tmp/**"}, path: "joe", match: false},
+	}
+
+	for _, tc := range tt {
+		scope, err := newPathScope(tc.patterns...)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.match, scope.Match(tc.path), "%v on %v", tc.patterns, tc.path)
+	}
+
+	_, err := newPathScope("[")
+	assert.NotNil(t, err)
+	_, err = newPathScope()
+	assert.NotNil(t, err)
+}
+
+func TestPathScopeRequest(t *testing.T) {
+	scope, err := newPathScope("out/**")
+	assert.Nil(t, err)
+
+	assert.True(t, scope.MatchRequest(&GeneralRequest{Path: "out", Name: "joe"}))
+	assert.True(t, scope.MatchRequest(&GeneralRequest{Path: "src", OldName: "joe", NewDir: "out", NewName: "joe"}))
+	assert.False(t, scope.MatchRequest(&GeneralRequest{Path: "src", OldName: "joe", NewDir: "src", NewName: "leo"}))
+	assert.True(t, scope.MatchRequest(&GeneralRequest{Path: "out/joe", Data: []byte("leo")}))
+}
@@ -0,0 +1,39 @@
+// +build freebsd
+
+package resonatefuse
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// statfs returns the capacity of the file system holding path
+func statfs(path string) (*StatfsRequest, error) {
+	st := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, errors.Wrapf(err, "could not statfs %v", path)
+	}
+
+	// Blocks reserved for root make the available count negative when full
+	bavail := uint64(0)
+	if st.Bavail > 0 {
+		bavail = uint64(st.Bavail)
+	}
+
+	ffree := uint64(0)
+	if st.Ffree > 0 {
+		ffree = uint64(st.Ffree)
+	}
+
+	return &StatfsRequest{
+		Blocks:  st.Blocks,
+		Bfree:   st.Bfree,
+		Bavail:  bavail,
+		Files:   st.Files,
+		Ffree:   ffree,
+		Bsize:   uint32(st.Iosize),
+		Namelen: st.Namemax,
+		Frsize:  uint32(st.Bsize),
+	}, nil
+}
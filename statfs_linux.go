@@ -0,0 +1,28 @@
+// +build linux
+
+package resonatefuse
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// statfs returns the capacity of the file system holding path
+func statfs(path string) (*StatfsRequest, error) {
+	st := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, errors.Wrapf(err, "could not statfs %v", path)
+	}
+
+	return &StatfsRequest{
+		Blocks:  st.Blocks,
+		Bfree:   st.Bfree,
+		Bavail:  st.Bavail,
+		Files:   st.Files,
+		Ffree:   st.Ffree,
+		Bsize:   uint32(st.Bsize),
+		Namelen: uint32(st.Namelen),
+		Frsize:  uint32(st.Frsize),
+	}, nil
+}
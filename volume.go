@@ -19,9 +19,13 @@ func (v *Volume) Server() *fs.Server {
 	return v.serv
 }
 
-func NewVolume(name string, opts ...Option) *Volume {
-	v := &Volume{fs: NewFS(name, opts...)}
-	return v
+func NewVolume(name string, opts ...Option) (*Volume, error) {
+	fs, err := NewFS(name, opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create volume (%v)", name)
+	}
+
+	return &Volume{fs: fs}, nil
 }
 
 func (v *Volume) Fuse() *FS {
@@ -33,13 +37,14 @@ func (v *Volume) mount() error {
 		return errors.Wrapf(err, "could not create mount point for volume (%v)", v.fs.Location())
 	}
 
-	c, err := fuse.Mount(
-		v.fs.Location(),
+	options := append([]fuse.MountOption{
 		fuse.FSName("resonatefuse"),
 		fuse.Subtype("resonatefuse"),
 		fuse.LocalVolume(),
 		fuse.VolumeName(v.fs.Location()),
-	)
+	}, v.fs.mountOptions...)
+
+	c, err := fuse.Mount(v.fs.Location(), options...)
 
 	if err != nil {
 		return errors.Wrapf(err, "could not initalize mount volume (%v)", v.fs.Location())
@@ -84,11 +89,15 @@ func (v *Volume) Stop() error {
 	if err := v.conn.Close(); err != nil {
 		return errors.Wrapf(err, "could not stop volume (%v)", v.fs.Location())
 	}
+
+	// No more operations arrive, let asynchronous hooks catch up (the volume
+	// may be served again, Close stops them for good)
+	v.fs.Drain()
 	if err := fuse.Unmount(v.fs.Location()); err != nil {
 		return errors.Wrapf(err, "could not unmount volume (%v)", v.fs.Location())
 	}
 
-	if err := rm(v.fs.Location()); err != nil {
+	if err := os.Remove(v.fs.Location()); err != nil {
 		return errors.Wrapf(err, "could not remove mountpoint of volume (%v)", v.fs.Location())
 	}
 
@@ -97,3 +106,12 @@ func (v *Volume) Stop() error {
 
 	return nil
 }
+
+// Close stops the volume and the workers of its asynchronous hooks, the
+// volume can not be served again afterwards
+func (v *Volume) Close() error {
+	err := v.Stop()
+	v.fs.Close()
+
+	return err
+}
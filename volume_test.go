@@ -0,0 +1,48 @@
+package resonatefuse
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolumeRestart(t *testing.T) {
+	origin, err := ioutil.TempDir("", "resonatefuse")
+	assert.Nil(t, err)
+	defer os.RemoveAll(origin)
+
+	seen := make(chan string, 1)
+	notify := func(req *GeneralRequest) error {
+		seen <- string(req.Data)
+		return nil
+	}
+
+	v, err := NewVolume(origin, GeneralOption(WriteType, notify, Async(1, 1, OverflowBlock)))
+	assert.Nil(t, err)
+	defer v.Close()
+	defer os.Remove(v.fs.Location())
+
+	// Asynchronous hooks keep working when a stopped volume is served again
+	for _, data := range []string{"joe", "leo"} {
+		if err := v.mount(); err != nil {
+			t.Skip("could not mount volume:", err)
+		}
+
+		served := make(chan error)
+		go func() { served <- v.Serve() }()
+
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(v.fs.Location(), "ali"), []byte(data), 0644))
+		assert.Nil(t, v.Stop())
+		<-served
+
+		select {
+		case got := <-seen:
+			assert.Equal(t, data, got)
+		default:
+			t.Error("asynchronous hook did not run")
+		}
+	}
+}
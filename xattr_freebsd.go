@@ -0,0 +1,27 @@
+// +build freebsd
+
+package resonatefuse
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// NOTE: Extended attributes are not supported on freebsd yet
+
+func getxattr(path, name string) ([]byte, error) {
+	return nil, errors.Wrapf(syscall.EOPNOTSUPP, "could not get xattr %v of %v", name, path)
+}
+
+func listxattr(path string) ([]string, error) {
+	return nil, errors.Wrapf(syscall.EOPNOTSUPP, "could not list xattrs of %v", path)
+}
+
+func setxattr(path, name string, value []byte, flags uint32) error {
+	return errors.Wrapf(syscall.EOPNOTSUPP, "could not set xattr %v of %v", name, path)
+}
+
+func removexattr(path, name string) error {
+	return errors.Wrapf(syscall.EOPNOTSUPP, "could not remove xattr %v of %v", name, path)
+}
@@ -0,0 +1,68 @@
+// +build linux
+
+package resonatefuse
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+func getxattr(path, name string) ([]byte, error) {
+	for {
+		size, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get xattr %v of %v", name, path)
+		}
+
+		value := make([]byte, size)
+		n, err := syscall.Getxattr(path, name, value)
+		// The value grew in between, try again
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get xattr %v of %v", name, path)
+		}
+
+		return value[:n], nil
+	}
+}
+
+func listxattr(path string) ([]string, error) {
+	for {
+		size, err := syscall.Listxattr(path, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not list xattrs of %v", path)
+		}
+
+		list := make([]byte, size)
+		n, err := syscall.Listxattr(path, list)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not list xattrs of %v", path)
+		}
+
+		names := strings.Split(string(list[:n]), "\x00")
+		return names[:len(names)-1], nil
+	}
+}
+
+func setxattr(path, name string, value []byte, flags uint32) error {
+	if err := syscall.Setxattr(path, name, value, int(flags)); err != nil {
+		return errors.Wrapf(err, "could not set xattr %v of %v", name, path)
+	}
+
+	return nil
+}
+
+func removexattr(path, name string) error {
+	if err := syscall.Removexattr(path, name); err != nil {
+		return errors.Wrapf(err, "could not remove xattr %v of %v", name, path)
+	}
+
+	return nil
+}